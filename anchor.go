@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// anchor returns name with the characters that can't appear in an HTML id
+// or URL fragment replaced. Different names can map to the same anchor, e.g.
+// "a/b" and "a_b", so templates get anchors from an anchorSet instead.
+func anchor(name interface{}) string {
+	return specialCharsPattern.ReplaceAllString(strings.ReplaceAll(fmt.Sprint(name), "/", "_"), "-")
+}
+
+// anchorSet hands out the anchors of a render pass. A name always gets the
+// same anchor, and names whose anchors collide are told apart by a numeric
+// suffix, e.g. "a_b" and "a_b-2".
+type anchorSet struct {
+	ids   map[string]string // by name
+	taken map[string]bool
+}
+
+// newAnchorSet returns an anchorSet with the declarations of files already
+// registered in sorted order, so that which of two colliding declarations
+// gets the suffix doesn't depend on the order templates ask for them.
+func newAnchorSet(files []*protogen.File) *anchorSet {
+	s := &anchorSet{ids: make(map[string]string), taken: make(map[string]bool)}
+	var names []string
+	for _, f := range files {
+		names = appendDeclarationNames(names, f.Desc)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s.anchor(name)
+	}
+	return s
+}
+
+// anchor returns the anchor of name, assigning one if name is new.
+func (s *anchorSet) anchor(name interface{}) string {
+	key := fmt.Sprint(name)
+	if id, ok := s.ids[key]; ok {
+		return id
+	}
+	base := anchor(key)
+	id := base
+	for i := 2; s.taken[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	s.ids[key] = id
+	s.taken[id] = true
+	return id
+}
+
+// appendDeclarationNames appends the full names of the services, methods,
+// messages, enums and extensions declared in fd, nested ones included.
+func appendDeclarationNames(names []string, fd protoreflect.FileDescriptor) []string {
+	var appendMessages func(msgs protoreflect.MessageDescriptors)
+	appendEnums := func(enums protoreflect.EnumDescriptors) {
+		for i := 0; i < enums.Len(); i++ {
+			names = append(names, string(enums.Get(i).FullName()))
+		}
+	}
+	appendExtensions := func(exts protoreflect.ExtensionDescriptors) {
+		for i := 0; i < exts.Len(); i++ {
+			names = append(names, string(exts.Get(i).FullName()))
+		}
+	}
+	appendMessages = func(msgs protoreflect.MessageDescriptors) {
+		for i := 0; i < msgs.Len(); i++ {
+			md := msgs.Get(i)
+			names = append(names, string(md.FullName()))
+			appendMessages(md.Messages())
+			appendEnums(md.Enums())
+			appendExtensions(md.Extensions())
+		}
+	}
+	services := fd.Services()
+	for i := 0; i < services.Len(); i++ {
+		sd := services.Get(i)
+		names = append(names, string(sd.FullName()))
+		for j := 0; j < sd.Methods().Len(); j++ {
+			names = append(names, string(sd.Methods().Get(j).FullName()))
+		}
+	}
+	appendMessages(fd.Messages())
+	appendEnums(fd.Enums())
+	appendExtensions(fd.Extensions())
+	return names
+}
+
+// anchor returns the anchor of name in the current render pass.
+func (o *GenOpts) anchor(name interface{}) string {
+	if o.anchors == nil {
+		o.anchors = newAnchorSet(nil)
+	}
+	return o.anchors.anchor(name)
+}
+
+// fieldAnchor returns the anchor of the message or enum the field f refers
+// to, or of the values of a map field, and "" for primitive types and
+// excluded types, which aren't documented. The anchor is the one o.anchor
+// gives the type, so a link to it matches the section documenting the type.
+func (o *GenOpts) fieldAnchor(f *protogen.Field) string {
+	if f.Desc.IsMap() {
+		f = f.Message.Fields[1]
+	}
+	switch {
+	case f.Message != nil && !excludedDeclaration(f.Message.Desc):
+		return o.anchor(f.Message.Desc.FullName())
+	case f.Enum != nil && !excludedDeclaration(f.Enum.Desc):
+		return o.anchor(f.Enum.Desc.FullName())
+	}
+	return ""
+}
+
+// methodInputAnchor returns the anchor of the request message of m, the one
+// o.anchor gives the message wherever it is declared.
+func (o *GenOpts) methodInputAnchor(m *protogen.Method) string {
+	return o.anchor(m.Input.Desc.FullName())
+}
+
+// methodOutputAnchor returns the anchor of the response message of m.
+func (o *GenOpts) methodOutputAnchor(m *protogen.Method) string {
+	return o.anchor(m.Output.Desc.FullName())
+}
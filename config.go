@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyConfig sets the options of flags from the YAML or JSON file at path,
+// whose keys are the names of the plugin parameters, e.g.
+//
+//	format: html
+//	title: Acme API
+//	include-package: [com.acme.*]
+//	frontmatter: {weight: 10}
+//
+// Lists set repeatable parameters once per element and maps set parameters
+// taking key=value pairs once per entry. Parameters passed explicitly take
+// precedence, so options of the file that were already set, under their own
+// name or an alias, are left alone. Unknown keys are an error.
+func applyConfig(flags *flag.FlagSet, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("config %s: %v", path, err)
+	}
+	var explicit []flag.Value
+	flags.Visit(func(f *flag.Flag) {
+		explicit = append(explicit, f.Value)
+	})
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f := flags.Lookup(key)
+		if f == nil || key == "config" {
+			return fmt.Errorf("config %s: unknown option %q", path, key)
+		}
+		if isExplicit(explicit, f.Value) {
+			continue
+		}
+		values, err := configValues(config[key])
+		if err != nil {
+			return fmt.Errorf("config %s: %s: %v", path, key, err)
+		}
+		set := f.Value.Set
+		// Values of the file aren't passed through protoc, so they are
+		// taken literally rather than URL-decoded.
+		if l, ok := f.Value.(literalValue); ok {
+			set = l.SetLiteral
+		}
+		for _, v := range values {
+			if err := set(v); err != nil {
+				return fmt.Errorf("config %s: %s: %v", path, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// literalValue is implemented by flags whose parameters are URL-decoded, to
+// set the values of config files as they are.
+type literalValue interface {
+	SetLiteral(s string) error
+}
+
+// isExplicit reports whether v was set by a parameter. Aliases share the
+// variable they set, so their values compare equal.
+func isExplicit(explicit []flag.Value, v flag.Value) bool {
+	for _, e := range explicit {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// configValues returns the parameter values of a config entry: a scalar
+// as is, the elements of a list, or the entries of a map as key=value pairs
+// sorted by key.
+func configValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return []string{""}, nil
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			s, err := configScalar(e)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var values []string
+		for _, k := range keys {
+			s, err := configScalar(v[k])
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, k+"="+s)
+		}
+		return values, nil
+	}
+	s, err := configScalar(v)
+	return []string{s}, err
+}
+
+func configScalar(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string, bool, int, float64:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// Sections rendered by templates, see GenOpts.Content.
+const (
+	// contentAll renders services, messages and enums.
+	contentAll = "all"
+	// contentServices renders services only, with the fields of the
+	// request and response messages listed under each method, see
+	// methodMessages.
+	contentServices = "services"
+	// contentMessages renders messages, enums and extensions only.
+	contentMessages = "messages"
+)
+
+// MethodMessage is the request or response message of a method, listed
+// under the method when only services are rendered.
+type MethodMessage struct {
+	// Role is "Request" or "Response".
+	Role    string
+	Message *protogen.Message
+	// Streaming is set when a stream of messages is sent.
+	Streaming bool
+	// Fields are the fields of Message in declaration order, including
+	// those of its oneofs.
+	Fields []*protogen.Field
+}
+
+// methodInput returns the request message of m, which may be declared in
+// another file than m.
+func methodInput(m *protogen.Method) *protogen.Message { return m.Input }
+
+// methodOutput returns the response message of m, which may be declared in
+// another file than m.
+func methodOutput(m *protogen.Method) *protogen.Message { return m.Output }
+
+// methodMessages returns the request and response messages of m.
+func methodMessages(m *protogen.Method) []MethodMessage {
+	return []MethodMessage{
+		{Role: "Request", Message: m.Input, Streaming: m.Desc.IsStreamingClient(), Fields: m.Input.Fields},
+		{Role: "Response", Message: m.Output, Streaming: m.Desc.IsStreamingServer(), Fields: m.Output.Fields},
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// csvHeader names the columns written by renderCSV.
+var csvHeader = []string{"message", "field", "type", "label", "number", "deprecated", "description"}
+
+// renderCSV writes a row for every field of every message, nested messages
+// included, for reviewing fields in a spreadsheet. The description column
+// holds the first line of the field's comment.
+func (o *GenOpts) renderCSV(data *TemplateData, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, f := range data.Files {
+		for _, msg := range f.Messages {
+			if err := writeCSVMessage(cw, msg); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeCSVMessage(cw *csv.Writer, msg *protogen.Message) error {
+	if msg.Desc.IsMapEntry() {
+		// Map entries are described by the type of the map field.
+		return nil
+	}
+	for _, f := range msg.Fields {
+		typ := fullFieldType(f)
+		if f.Desc.IsMap() {
+			typ = mapType(f)
+		}
+		description := strings.SplitN(commentSetText(f.Comments), "\n", 2)[0]
+		err := cw.Write([]string{
+			string(msg.Desc.FullName()),
+			string(f.Desc.Name()),
+			typ,
+			fieldLabel(f),
+			strconv.Itoa(int(f.Desc.Number())),
+			strconv.FormatBool(isDeprecated(f.Desc)),
+			description,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, nested := range msg.Messages {
+		if err := writeCSVMessage(cw, nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
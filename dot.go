@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Node shapes of the dot format.
+const (
+	dotMessageShape  = "box"
+	dotEnumShape     = "ellipse"
+	dotServiceShape  = "component"
+	dotImportedShape = "note"
+)
+
+// How the dot format draws the well-known types, see GenOpts.DotWKT.
+const (
+	// dotWKTKeep draws a node per well-known type.
+	dotWKTKeep = "keep"
+	// dotWKTCollapse draws a single node for all well-known types.
+	dotWKTCollapse = "collapse"
+	// dotWKTOmit leaves the well-known types and the edges to them out.
+	dotWKTOmit = "omit"
+)
+
+// wktPackage is the package of the well-known types.
+const wktPackage = "google.protobuf"
+
+// dotGraph collects the nodes and edges of a Graphviz digraph in the order
+// they are added.
+type dotGraph struct {
+	name     string
+	nodes    []string
+	declared map[string]bool // by node ID
+	edges    []string
+	// local holds the files being documented; types declared elsewhere are
+	// drawn as imported.
+	local map[string]bool
+	// anchor returns the node ID of a declaration.
+	anchor func(name interface{}) string
+	// wkt is how well-known types are drawn, one of the dotWKT constants.
+	wkt string
+}
+
+// renderDot writes a Graphviz digraph of the messages and enums of the
+// document and the fields referencing them. Map fields are drawn as dashed
+// edges to their value type, services have edges to their request and
+// response messages, and types declared in other files get a node shape of
+// their own. Nodes are identified by the anchors of their declarations and
+// labeled with their long names.
+func (o *GenOpts) renderDot(data *TemplateData, w io.Writer) error {
+	g := &dotGraph{
+		name:     o.title(),
+		declared: make(map[string]bool),
+		local:    make(map[string]bool),
+		anchor:   o.anchor,
+		wkt:      o.DotWKT,
+	}
+	if g.wkt == "" {
+		g.wkt = dotWKTKeep
+	}
+	if data.File != nil {
+		g.name = string(data.Desc.Package())
+	}
+	for _, f := range data.Files {
+		g.local[f.Desc.Path()] = true
+	}
+	for _, f := range data.Files {
+		for _, s := range f.Services {
+			g.node(s.Desc, dotServiceShape)
+		}
+		for _, msg := range f.Messages {
+			g.addMessage(msg)
+		}
+		for _, e := range f.Enums {
+			g.node(e.Desc, dotEnumShape)
+		}
+	}
+	for _, f := range data.Files {
+		for _, s := range f.Services {
+			for _, m := range s.Methods {
+				g.edge(s.Desc, m.Input.Desc, string(m.Desc.Name()), false)
+				if m.Output != m.Input {
+					g.edge(s.Desc, m.Output.Desc, string(m.Desc.Name()), false)
+				}
+			}
+		}
+		for _, msg := range f.Messages {
+			g.addFieldEdges(msg)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %s {\n", dotID(g.name))
+	fmt.Fprintf(bw, "  rankdir=LR;\n")
+	for _, n := range g.nodes {
+		fmt.Fprintf(bw, "  %s;\n", n)
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(bw, "  %s;\n", e)
+	}
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+// addMessage adds the nodes of msg and the messages and enums nested in it.
+// Map entries are left out, map fields point at their value type instead.
+func (g *dotGraph) addMessage(msg *protogen.Message) {
+	if msg.Desc.IsMapEntry() {
+		return
+	}
+	g.node(msg.Desc, dotMessageShape)
+	for _, nested := range msg.Messages {
+		g.addMessage(nested)
+	}
+	for _, e := range msg.Enums {
+		g.node(e.Desc, dotEnumShape)
+	}
+}
+
+// addFieldEdges adds an edge per message or enum typed field of msg and the
+// messages nested in it.
+func (g *dotGraph) addFieldEdges(msg *protogen.Message) {
+	if msg.Desc.IsMapEntry() {
+		return
+	}
+	for _, f := range msg.Fields {
+		name, typ, dashed := string(f.Desc.Name()), f, false
+		if f.Desc.IsMap() {
+			typ, dashed = f.Message.Fields[1], true
+		}
+		switch {
+		case typ.Message != nil:
+			g.edge(msg.Desc, typ.Message.Desc, name, dashed)
+		case typ.Enum != nil:
+			g.edge(msg.Desc, typ.Enum.Desc, name, dashed)
+		}
+	}
+	for _, nested := range msg.Messages {
+		g.addFieldEdges(nested)
+	}
+}
+
+// node declares d with shape and returns its ID. Declarations of files that
+// aren't documented are drawn as imported and labeled with their full names,
+// and well-known types are collapsed or left out as configured, in which
+// case node returns "". Nodes are declared once.
+func (g *dotGraph) node(d protoreflect.Descriptor, shape string) string {
+	id, label := g.anchor(d.FullName()), longName(d)
+	if !g.local[d.ParentFile().Path()] {
+		shape, label = dotImportedShape, string(d.FullName())
+		if d.ParentFile().Package() == wktPackage {
+			switch g.wkt {
+			case dotWKTOmit:
+				return ""
+			case dotWKTCollapse:
+				id, label = g.anchor(wktPackage), wktPackage
+			}
+		}
+	}
+	if !g.declared[id] {
+		g.declared[id] = true
+		g.nodes = append(g.nodes, fmt.Sprintf("%s [label=%s, shape=%s]", dotID(id), dotID(label), shape))
+	}
+	return id
+}
+
+// edge adds an edge labeled label from one declaration to another, declaring
+// the target if it hasn't been yet. Edges to omitted types are left out.
+func (g *dotGraph) edge(from, to protoreflect.Descriptor, label string, dashed bool) {
+	shape := dotMessageShape
+	if _, ok := to.(protoreflect.EnumDescriptor); ok {
+		shape = dotEnumShape
+	}
+	target := g.node(to, shape)
+	if target == "" {
+		return
+	}
+	attrs := "label=" + dotID(label)
+	if dashed {
+		attrs += ", style=dashed"
+	}
+	g.edges = append(g.edges, fmt.Sprintf("%s -> %s [%s]", dotID(g.anchor(from.FullName())), dotID(target), attrs))
+}
+
+// dotID quotes s as a DOT identifier.
+func dotID(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
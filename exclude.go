@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// excludeMarker starts the leading comment of declarations that are left out
+// of the documentation.
+const excludeMarker = "@exclude"
+
+// isExcluded reports whether the leading comment of a declaration starts
+// with excludeMarker.
+func isExcluded(c protogen.CommentSet) bool {
+	return strings.HasPrefix(strings.TrimLeft(string(c.Leading), "*/\n "), excludeMarker)
+}
+
+// excludedDeclaration reports whether d, or a message it is nested in, is
+// excluded, so that there is no section to link to.
+func excludedDeclaration(d protoreflect.Descriptor) bool {
+	for ; d != nil; d = d.Parent() {
+		if _, ok := d.(protoreflect.FileDescriptor); ok {
+			break
+		}
+		loc := d.ParentFile().SourceLocations().ByDescriptor(d)
+		if isExcluded(protogen.CommentSet{Leading: protogen.Comments(loc.LeadingComments)}) {
+			return true
+		}
+	}
+	return false
+}
+
+// enumValues returns the values of e that are not excluded.
+func enumValues(e *protogen.Enum) []*protogen.EnumValue {
+	var values []*protogen.EnumValue
+	for _, v := range e.Values {
+		if !isExcluded(v.Comments) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// pruneExcluded removes excluded services, methods, messages, fields, enums
+// and enum values from file, so that every format leaves them out.
+func pruneExcluded(file *protogen.File) {
+	services := file.Services[:0]
+	for _, s := range file.Services {
+		if isExcluded(s.Comments) {
+			continue
+		}
+		methods := s.Methods[:0]
+		for _, m := range s.Methods {
+			if !isExcluded(m.Comments) {
+				methods = append(methods, m)
+			}
+		}
+		s.Methods = methods
+		services = append(services, s)
+	}
+	file.Services = services
+	file.Messages = pruneMessages(file.Messages)
+	file.Enums = pruneEnums(file.Enums)
+}
+
+func pruneMessages(msgs []*protogen.Message) []*protogen.Message {
+	kept := msgs[:0]
+	for _, m := range msgs {
+		if isExcluded(m.Comments) {
+			continue
+		}
+		m.Fields = pruneFields(m.Fields)
+		oneofs := m.Oneofs[:0]
+		for _, o := range m.Oneofs {
+			// Oneofs whose fields are all excluded are dropped with them.
+			if o.Fields = pruneFields(o.Fields); len(o.Fields) > 0 {
+				oneofs = append(oneofs, o)
+			}
+		}
+		m.Oneofs = oneofs
+		m.Messages = pruneMessages(m.Messages)
+		m.Enums = pruneEnums(m.Enums)
+		kept = append(kept, m)
+	}
+	return kept
+}
+
+func pruneFields(fields []*protogen.Field) []*protogen.Field {
+	kept := fields[:0]
+	for _, f := range fields {
+		if !isExcluded(f.Comments) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+func pruneEnums(enums []*protogen.Enum) []*protogen.Enum {
+	kept := enums[:0]
+	for _, e := range enums {
+		if isExcluded(e.Comments) {
+			continue
+		}
+		e.Values = enumValues(e)
+		kept = append(kept, e)
+	}
+	return kept
+}
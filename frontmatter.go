@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterFlag collects the key=value pairs of repeated frontmatter
+// parameters, whose values may be URL-encoded like those of escapedFlag.
+// protoc splits parameters on commas, so each pair is passed as a parameter
+// of its own.
+type frontMatterFlag []string
+
+func (f *frontMatterFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *frontMatterFlag) Set(s string) error {
+	v, err := url.PathUnescape(s)
+	if err != nil {
+		return fmt.Errorf("invalid escape in %q: %v", s, err)
+	}
+	return f.SetLiteral(v)
+}
+
+func (f *frontMatterFlag) SetLiteral(s string) error {
+	pair := strings.SplitN(s, "=", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return fmt.Errorf("front matter %q is not of the form key=value", s)
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// frontMatterField is a field of the front matter of a document.
+type frontMatterField struct {
+	Key, Value string
+}
+
+// automaticFrontMatter returns the front matter fields every document gets
+// when front matter is configured: the title, the package of a single file
+// or the configured title, and the proto_file the document was generated
+// from.
+func (o *GenOpts) automaticFrontMatter(data *TemplateData) []frontMatterField {
+	if data.File == nil {
+		return []frontMatterField{{"title", o.title()}}
+	}
+	return []frontMatterField{
+		{"title", string(data.Desc.Package())},
+		{"proto_file", data.Desc.Path()},
+	}
+}
+
+// frontMatter implements the front_matter template function, returning the
+// YAML front matter of a document between "---" delimiters: the fields the
+// template passes as key and value pairs, e.g.
+// front_matter "title" .Desc.Package "search" true, followed by the
+// automatic fields the template doesn't set and by the configured fields,
+// which replace fields of the same key. Fields of zero values, such as empty
+// strings, are left out. A document whose template doesn't call front_matter
+// is given the automatic and configured fields, see render.
+func (o *GenOpts) frontMatter(pairs ...interface{}) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("front_matter takes key and value pairs, got %d arguments", len(pairs))
+	}
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	values := make(map[string]*yaml.Node)
+	add := func(key string, value *yaml.Node) {
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+		values[key] = value
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return "", fmt.Errorf("front_matter key %v is not a string", pairs[i])
+		}
+		if v := reflect.ValueOf(pairs[i+1]); !v.IsValid() || v.IsZero() {
+			continue
+		}
+		value := &yaml.Node{}
+		if err := value.Encode(pairs[i+1]); err != nil {
+			return "", fmt.Errorf("front_matter %s: %v", key, err)
+		}
+		add(key, value)
+	}
+	for _, f := range o.documentFrontMatter {
+		if _, ok := values[f.Key]; !ok {
+			add(f.Key, &yaml.Node{Kind: yaml.ScalarNode, Value: f.Value})
+		}
+	}
+	for _, kv := range o.FrontMatter {
+		pair := strings.SplitN(kv, "=", 2)
+		key, value := pair[0], &yaml.Node{Kind: yaml.ScalarNode, Value: pair[1]}
+		if v, ok := values[key]; ok {
+			*v = *value
+			continue
+		}
+		add(key, value)
+	}
+	o.wroteFrontMatter = true
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return "---\n" + b.String() + "---\n", nil
+}
+
+// frontMatterFields returns the automatic and configured front matter fields
+// of the current document by key, or nil when none are configured.
+func (o *GenOpts) frontMatterFields() map[string]string {
+	if len(o.FrontMatter) == 0 {
+		return nil
+	}
+	fields := make(map[string]string)
+	for _, f := range o.documentFrontMatter {
+		fields[f.Key] = f.Value
+	}
+	for _, kv := range o.FrontMatter {
+		pair := strings.SplitN(kv, "=", 2)
+		fields[pair[0]] = pair[1]
+	}
+	return fields
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"path"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// How documents are organized, see GenOpts.GroupBy.
+const (
+	// groupFile renders a section, or a document, per .proto file.
+	groupFile = "file"
+	// groupPackage renders a chapter, or a document, per proto package
+	// holding the services, messages and enums of all of its files.
+	groupPackage = "package"
+)
+
+// packageDocPath returns the name of the document of pkg when generating a
+// document per package: the package name in the directory of its first
+// file, e.g. "acme/v1/acme.v1.md".
+func (o *GenOpts) packageDocPath(pkg *PackageFiles) string {
+	return o.docPath(path.Join(path.Dir(pkg.Files[0].GeneratedFilenamePrefix), string(pkg.Name)) + "." + o.fileSuffix())
+}
+
+// generatePackages generates a document per proto package of files with
+// the "combined" template. Links to declarations of other packages point at
+// the documents of their packages.
+func (o *GenOpts) generatePackages(gen *protogen.Plugin, files []*protogen.File) error {
+	packages := groupByPackage(files)
+	o.docPaths = make(map[string]string)
+	defer func() { o.docPaths = nil }()
+	seen := make(map[string]protoreflect.FullName)
+	for _, pkg := range packages {
+		filename := o.packageDocPath(pkg)
+		if prev, ok := seen[filename]; ok {
+			return fmt.Errorf("packages %v and %v are both generated to %v", prev, pkg.Name, filename)
+		}
+		seen[filename] = pkg.Name
+		for _, f := range pkg.Files {
+			o.docPaths[f.Desc.Path()] = filename
+		}
+	}
+	for _, pkg := range packages {
+		filename := o.packageDocPath(pkg)
+		data := &TemplateData{Files: pkg.Files, Packages: groupByPackage(pkg.Files)}
+		if err := o.render(gen.NewGeneratedFile(filename, ""), filename, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// HTTPRule is the REST mapping of a method, read from its google.api.http
+// option.
+type HTTPRule struct {
+	// Method is the HTTP method, e.g. "GET", or the kind of a custom pattern.
+	Method string
+	// Path is the URL path template, e.g. "/v1/shelves/{id}".
+	Path string
+	// Body is the request field mapped to the request body, "*" for every
+	// field not bound by the path, or empty for no body.
+	Body string
+	// ResponseBody is the response field mapped to the response body, or
+	// empty for the whole response.
+	ResponseBody string
+}
+
+// httpRuleExtension is the option holding a method's REST mapping.
+const httpRuleExtension = "google.api.http"
+
+// httpRules returns the REST mappings of m, the primary binding first
+// followed by its additional bindings. It returns an empty slice if m has no
+// google.api.http option.
+func httpRules(m *protogen.Method) []HTTPRule {
+	rules := []HTTPRule{}
+	v, ok := extensionValue(m.Desc, httpRuleExtension)
+	if !ok {
+		return rules
+	}
+	rule := v.Message()
+	rules = append(rules, newHTTPRule(rule))
+	if fd := rule.Descriptor().Fields().ByName("additional_bindings"); fd != nil {
+		bindings := rule.Get(fd).List()
+		for i := 0; i < bindings.Len(); i++ {
+			rules = append(rules, newHTTPRule(bindings.Get(i).Message()))
+		}
+	}
+	return rules
+}
+
+func newHTTPRule(rule protoreflect.Message) HTTPRule {
+	var r HTTPRule
+	fields := rule.Descriptor().Fields()
+	str := func(name protoreflect.Name) string {
+		if fd := fields.ByName(name); fd != nil {
+			return rule.Get(fd).String()
+		}
+		return ""
+	}
+	for _, method := range []protoreflect.Name{"get", "put", "post", "delete", "patch"} {
+		if fd := fields.ByName(method); fd != nil && rule.Has(fd) {
+			r.Method = strings.ToUpper(string(method))
+			r.Path = rule.Get(fd).String()
+		}
+	}
+	if fd := fields.ByName("custom"); fd != nil && rule.Has(fd) {
+		custom := rule.Get(fd).Message()
+		if kind := custom.Descriptor().Fields().ByName("kind"); kind != nil {
+			r.Method = custom.Get(kind).String()
+		}
+		if path := custom.Descriptor().Fields().ByName("path"); path != nil {
+			r.Path = custom.Get(path).String()
+		}
+	}
+	r.Body = str("body")
+	r.ResponseBody = str("response_body")
+	return r
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// includeFile returns the contents of the file name below o.IncludeDir, for
+// templates to embed hand-written sections with include_file. name is a
+// slash-separated path relative to the directory, so absolute paths and
+// paths leaving it with ".." are an error. An optional indent is a number of
+// spaces prefixed to every line but empty ones, e.g. for the body of an
+// AsciiDoc or reStructuredText block.
+func (o *GenOpts) includeFile(name string, indent ...int) (string, error) {
+	if o.IncludeDir == "" {
+		return "", fmt.Errorf("cannot include %q without include_dir", name)
+	}
+	if len(indent) > 1 || len(indent) == 1 && indent[0] < 0 {
+		return "", fmt.Errorf("invalid indent %v of %q, want a number of spaces", indent, name)
+	}
+	clean := path.Clean(name)
+	if !fs.ValidPath(clean) || clean == "." {
+		return "", fmt.Errorf("%q is not a path relative to include_dir %s", name, o.IncludeDir)
+	}
+	b, err := os.ReadFile(filepath.Join(o.IncludeDir, filepath.FromSlash(clean)))
+	if err != nil {
+		return "", err
+	}
+	content := string(b)
+	if len(indent) == 0 || indent[0] == 0 {
+		return content, nil
+	}
+	prefix := strings.Repeat(" ", indent[0])
+	lines := strings.SplitAfter(content, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, ""), nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// IndexData is the data the index template is executed with.
+type IndexData struct {
+	// Packages holds the generated documents grouped by proto package, in
+	// the order protoc passed the files.
+	Packages []IndexPackage
+	// Title, Version and Description are the configured title, version and
+	// introduction of the documentation, or empty.
+	Title       string
+	Version     string
+	Description string
+	// Meta describes how the documentation was generated.
+	Meta    GenerationMeta
+	Options RenderOptions
+	// Vars holds the values of var parameters, and Extra the same under the
+	// name of the template-data alias.
+	Vars  map[string]string
+	Extra map[string]string
+}
+
+// IndexPackage is a proto package listed in the index.
+type IndexPackage struct {
+	Name string
+	Docs []IndexDoc
+}
+
+// IndexDoc is a generated document listed in the index.
+type IndexDoc struct {
+	File *protogen.File
+	// Link is the path of the document relative to the index.
+	Link string
+	// Summary is the first line of the leading comment of the file, see
+	// fileComment.
+	Summary string
+}
+
+// indexTemplateName returns the name of the template rendering the index
+// named filename, e.g. "index.md.tmpl" for "README.md".
+func indexTemplateName(filename string) string {
+	return "index" + path.Ext(filename) + ".tmpl"
+}
+
+// generateIndex writes an index of pages grouped by proto package to
+// o.Index, below OutSubdir, by executing the "index" template of
+// indexTemplateName. Templates are looked up like the templates of formats,
+// so the index can be customized with TemplateDirs.
+func (o *GenOpts) generateIndex(gen *protogen.Plugin, pages []generatedPage) error {
+	filename := o.outPath(o.Index)
+	data := &IndexData{Title: o.Title, Version: o.Version, Description: o.Description, Meta: o.meta, Options: o.renderOptions(), Vars: o.Vars, Extra: o.Vars}
+	packages := make(map[string]int)
+	for _, p := range pages {
+		pkg := string(p.File.Desc.Package())
+		i, ok := packages[pkg]
+		if !ok {
+			i = len(data.Packages)
+			packages[pkg] = i
+			data.Packages = append(data.Packages, IndexPackage{Name: pkg})
+		}
+		link := p.Filename
+		if rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash(filename)), filepath.FromSlash(link)); err == nil {
+			link = filepath.ToSlash(rel)
+		}
+		summary := commentText(fileComment(p.File))
+		if i := strings.Index(summary, "\n"); i >= 0 {
+			summary = summary[:i]
+		}
+		data.Packages[i].Docs = append(data.Packages[i].Docs, IndexDoc{File: p.File, Link: link, Summary: summary})
+	}
+
+	layers, err := o.templateLayers()
+	if err != nil {
+		return err
+	}
+	files, err := templateFiles(layers, indexTemplateName(o.Index), "")
+	if err != nil {
+		return fmt.Errorf("issue generating %v: %w", filename, err)
+	}
+	t := template.New("file.tmpl").Funcs(o.funcMap(false))
+	err = parseTemplateFiles(files, func(name, text string, custom bool) error {
+		_, err := t.New(name).Delims(o.delims(custom)).Parse(text)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("issue generating %v: %w", filename, err)
+	}
+	st := sourcedTemplate{templateExecutor: t, o: o, sources: templateSources(files)}
+	if err := st.ExecuteTemplate(gen.NewGeneratedFile(filename, ""), "index", data); err != nil {
+		return fmt.Errorf("issue generating %v: %w", filename, err)
+	}
+	return nil
+}
@@ -2,18 +2,26 @@ package main
 
 import (
 	"embed"
+	"errors"
 	"flag"
 	"fmt"
 	htmltemplate "html/template"
+	"io"
 	"io/fs"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"unicode/utf8"
 
 	"github.com/Masterminds/sprig"
 	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/pluginpb"
 )
@@ -21,111 +29,1348 @@ import (
 func main() {
 	var flags flag.FlagSet
 	format := flags.String("format", "markdown", "Format to use")
-	templates := flags.String("templates", "", "Custom templates directory to use")
+	ext := flags.String("ext", "", "If supplied, the extension of generated files instead of the one of the format")
+	flags.StringVar(ext, "file-extension", "", "Alias of ext")
+	var templateDirs templateDirsFlag
+	flags.Var(&templateDirs, "templates", "Custom templates directory to use, searched before the embedded templates; may be repeated")
+	var delims delimsFlag
+	flags.Var(&delims, "delims", "If supplied, the left and right action delimiters of custom templates separated by a space, e.g. \"[[ ]]\"; the embedded templates keep {{ and }}")
+	templateFile := flags.String("template_file", "", "If supplied, the template rendered instead of the one of the format, e.g. api.md.tmpl, whose inner extension names the generated files")
+	includeDir := flags.String("include_dir", "", "If supplied, the directory the include_file template function reads files from, by paths relative to it")
 	trimPrefix := flags.String("trimprefix", "", "If supplied, this prefix will be removed from generated file paths.")
+	outSubdir := flags.String("out-subdir", "", "If supplied, generated files are written to this directory below the protoc output directory")
+	combine := flags.Bool("combine", false, "Render all files into a single document")
+	flags.BoolVar(combine, "merge", false, "Alias of combine")
+	stdout := flags.Bool("stdout", false, "If true, the combined document is named stdout, so that protoc writes it to its standard output with --apidocs_out=/dev; requires combine")
+	sidebarPositionStart := flags.Int("mdx_sidebar_position_start", 0, "If supplied, mdx documents get a sidebar_position starting at this number")
+	var frontMatter frontMatterFlag
+	flags.Var(&frontMatter, "frontmatter", "A key=value pair added to the front matter of generated documents; may be repeated and the value URL-encoded")
+	var wkt wktFlag
+	flags.Var(&wkt, "wkt", "A name=description pair recognizing a well-known type, or with an empty description ignoring one; may be repeated")
+	scalarNames := flags.String("scalar_names", scalarNamesProto, "How templates name scalar types: proto, e.g. int32, or generic, e.g. 32-bit integer")
+	var scalars scalarFlag
+	flags.Var(&scalars, "scalar", "A type=name pair displaying a scalar type under another name, e.g. bytes=byte string; may be repeated")
+	vars := make(varsFlag)
+	flags.Var(&vars, "var", "A key=value pair exposed to templates as .Vars.key; may be repeated and the value URL-encoded")
+	flags.Var(&vars, "template-data", "Alias of var")
+	var title, version, description, footer escapedFlag
+	flags.Var(&title, "title", "If supplied, the title of the documentation, rendered as a heading and used instead of \"API Reference\"; may be URL-encoded")
+	flags.Var(&version, "version", "If supplied, the version of the API, rendered below the title; may be URL-encoded")
+	flags.Var(&footer, "footer", "Footer of generated documents: none, generated for the plugin and protoc versions, or text rendered verbatim; may be URL-encoded")
+	flags.Var(&description, "description", "If supplied, an introduction rendered below the title; may be URL-encoded")
+	index := flags.String("index", "", "If supplied, an index linking the generated documents by package is written to this path, rendered with the index.<ext>.tmpl template")
+	mkdocsNav := flags.String("mkdocs_nav", "", "If supplied, a MkDocs nav fragment listing the generated pages is written to this path")
+	htmlStandalone := flags.Bool("html_standalone", false, "If true, html pages don't link to the pages of other files")
+	css := flags.String("css", "", "If supplied, the stylesheet inlined into html pages instead of the default one")
+	dotWKT := flags.String("dot_wkt", dotWKTKeep, "How the dot format draws well-known types: keep, collapse into a single node, or omit")
+	mermaid := flags.Bool("mermaid", false, "If true, markdown documents embed a mermaid class diagram of their messages and enums")
+	fieldLayout := flags.String("field_layout", fieldLayoutTable, "Layout of message fields in the markdown format: table or list")
+	groupBy := flags.String("group_by", groupFile, "How documents are organized: file for a section or document per .proto file, or package for a chapter or document per proto package")
+	sortOrder := flags.String("sort", sortDeclaration, "Order of messages, enums, fields and enum values: declaration, name, or number for fields and enum values")
+	content := flags.String("content", contentAll, "Sections rendered by the markdown, hugo-markdown and html formats: all, services with the fields of requests and responses listed under each method, or messages")
+	var includePackages, excludePackages, includeFiles, excludeFiles patternsFlag
+	flags.Var(&includePackages, "include-package", "A package pattern, e.g. com.acme.*; if any are supplied, only files of matching packages are documented")
+	flags.Var(&excludePackages, "exclude-package", "A package pattern, e.g. com.acme.internal.*; files of matching packages are not documented")
+	flags.Var(&includeFiles, "include", "A glob matched against proto paths and packages, e.g. acme/**; if any are supplied, only matching files are documented")
+	flags.Var(&excludeFiles, "exclude", "A glob matched against proto paths and packages, e.g. vendor/**; matching files are not documented")
+	skipEmptyServices := flags.Bool("skip_empty_services", false, "If true, files that define no services are not documented, unless combining or splitting and their types are referenced from files with services")
+	verbose := flags.Bool("verbose", false, "If true, the files left out by include, exclude and skip_empty_services are logged to stderr")
+	split := flags.String("split", splitFile, "How documents are split: file for a document per .proto file, service for a document per service, or page for an html page per declaration")
+	flags.StringVar(split, "split-by", splitFile, "Alias of split")
+	outputFile := flags.String("output-file", "", "If supplied, the name of the generated file; a template with {{.Package}}, {{.Dir}}, {{.Base}} and {{.Ext}} unless combining")
+	flags.StringVar(outputFile, "doc_path", "", "Alias of output-file")
+	flat := flags.Bool("flat", false, "If true, slashes in the names of generated files are replaced with dots, writing every document to one directory")
+	funcs := flags.String("funcs", funcsFull, "Functions available to templates: full, or minimal to leave out the sprig functions reading the environment or the network")
+	listFuncs := flags.Bool("list_funcs", false, "If true, the functions available to the templates of the format are listed on stderr")
+	config := flags.String("config", "", "If supplied, a YAML or JSON file setting any of these options by name; options passed explicitly take precedence")
 
 	opts := &protogen.Options{
 		ParamFunc: flags.Set,
 	}
 	opts.Run(func(gen *protogen.Plugin) error {
 		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
-		genOpts := GenOpts{
-			Format:      *format,
-			TemplateDir: *templates,
-			TrimPrefix:  *trimPrefix,
-		}
-		for _, f := range gen.Files {
-			if !f.Generate {
-				continue
-			}
-			if err := genOpts.generateFile(gen, f); err != nil {
+		if *config != "" {
+			if err := applyConfig(&flags, *config); err != nil {
 				return err
 			}
 		}
-		return nil
+		genOpts := GenOpts{
+			Format:       *format,
+			Ext:          *ext,
+			TemplateDirs: templateDirs,
+			TemplateFile: *templateFile,
+			LeftDelim:    delims[0],
+			RightDelim:   delims[1],
+			TrimPrefix:   *trimPrefix,
+			OutSubdir:    *outSubdir,
+			Flat:         *flat,
+			Combine:      *combine,
+			Stdout:       *stdout,
+			MkdocsNav:    *mkdocsNav,
+			Index:        *index,
+			Title:        string(title),
+			Version:      string(version),
+			Description:  string(description),
+			Footer:       string(footer),
+			FrontMatter:  frontMatter,
+			Vars:         vars,
+			OutputFile:   *outputFile,
+			FieldLayout:  *fieldLayout,
+			Content:      *content,
+			Sort:         *sortOrder,
+			Funcs:        *funcs,
+			ListFuncs:    *listFuncs,
+			IncludeDir:   *includeDir,
+			GroupBy:      *groupBy,
+			DotWKT:       *dotWKT,
+			Mermaid:      *mermaid,
+			WKT:          wkt,
+			ScalarNames:  *scalarNames,
+			Scalars:      scalars,
+			CSS:          *css,
+			Split:        *split,
+
+			IncludePackages:      includePackages,
+			ExcludePackages:      excludePackages,
+			IncludeFiles:         includeFiles,
+			ExcludeFiles:         excludeFiles,
+			SkipEmptyServices:    *skipEmptyServices,
+			Verbose:              *verbose,
+			HTMLStandalone:       *htmlStandalone,
+			SidebarPositionStart: *sidebarPositionStart,
+		}
+		return genOpts.generate(gen)
 	})
 }
 
+// escapedFlag is a string parameter that may be URL-encoded, since protoc
+// separates parameters with commas, e.g. "title=Acme%2C%20Inc." sets
+// "Acme, Inc.".
+type escapedFlag string
+
+func (f *escapedFlag) String() string {
+	return string(*f)
+}
+
+func (f *escapedFlag) Set(s string) error {
+	v, err := url.PathUnescape(s)
+	if err != nil {
+		return fmt.Errorf("invalid escape in %q: %v", s, err)
+	}
+	return f.SetLiteral(v)
+}
+
+func (f *escapedFlag) SetLiteral(s string) error {
+	*f = escapedFlag(s)
+	return nil
+}
+
+// varsFlag collects the key=value pairs of repeated var parameters, whose
+// values may be URL-encoded like those of escapedFlag. A key set again
+// replaces the earlier value.
+type varsFlag map[string]string
+
+func (f *varsFlag) String() string {
+	var pairs []string
+	for k, v := range *f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f *varsFlag) Set(s string) error {
+	v, err := url.PathUnescape(s)
+	if err != nil {
+		return fmt.Errorf("invalid escape in %q: %v", s, err)
+	}
+	return f.SetLiteral(v)
+}
+
+func (f *varsFlag) SetLiteral(s string) error {
+	pair := strings.SplitN(s, "=", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return fmt.Errorf("template variable %q is not of the form key=value", s)
+	}
+	(*f)[pair[0]] = pair[1]
+	return nil
+}
+
+// delimsFlag holds the left and right template delimiters of the delims
+// parameter. protoc separates parameters with commas, so the delimiters are
+// separated by a space, e.g. "[[ ]]", or by a URL-encoded comma, "[[%2C]]";
+// config files can use a plain comma.
+type delimsFlag [2]string
+
+func (f *delimsFlag) String() string {
+	if f[0] == "" && f[1] == "" {
+		return ""
+	}
+	return f[0] + " " + f[1]
+}
+
+func (f *delimsFlag) Set(s string) error {
+	v, err := url.PathUnescape(s)
+	if err != nil {
+		return fmt.Errorf("invalid escape in %q: %v", s, err)
+	}
+	return f.SetLiteral(v)
+}
+
+func (f *delimsFlag) SetLiteral(s string) error {
+	var delims []string
+	if strings.Contains(s, ",") {
+		delims = strings.Split(s, ",")
+	} else {
+		delims = strings.Fields(s)
+	}
+	if len(delims) != 2 {
+		return fmt.Errorf("delims %q is not a left and right delimiter separated by a space, e.g. \"[[ ]]\"", s)
+	}
+	*f = delimsFlag{strings.TrimSpace(delims[0]), strings.TrimSpace(delims[1])}
+	return nil
+}
+
 // GenOpts hold options for generation.
 type GenOpts struct {
-	Format      string
-	TemplateDir string
-	TrimPrefix  string
+	Format string
+	// Ext overrides the extension of generated files, see fileSuffix.
+	Ext string
+	// TemplateDirs are searched for templates in order before the embedded
+	// defaults, whose definitions they override, see templateFiles.
+	TemplateDirs []string
+	// TemplateFile is rendered instead of the template of the format, along
+	// with the embedded partials, see parseTemplate.
+	TemplateFile string
+	TrimPrefix   string
+	// LeftDelim and RightDelim are the action delimiters of the templates
+	// of TemplateDirs and TemplateFile. Empty means "{{" and "}}", which the
+	// embedded templates always use.
+	LeftDelim  string
+	RightDelim string
+	// OutSubdir is a directory, relative to the protoc output directory,
+	// that generated files are placed in, see outPath.
+	OutSubdir string
+	// Flat replaces the slashes of generated names with dots, so that
+	// every document is written to the same directory, see docPath.
+	Flat bool
+	// Combine renders all files into a single document named
+	// combinedFileName.
+	Combine bool
+	// Stdout names the combined document stdoutFileName instead, for
+	// protoc to write it to its standard output.
+	Stdout bool
+	// MkdocsNav is the path, below OutSubdir, of a MkDocs nav fragment
+	// listing every generated page, see generateMkdocsNav.
+	MkdocsNav string
+	// Title is the title of the documentation, see title. Version is the
+	// version of the documented API and Description an introduction to
+	// it. They are passed to templates.
+	Title       string
+	Version     string
+	Description string
+	// Footer is footerNone, footerGenerated or a text rendered verbatim at
+	// the end of documents, see generationMeta. Empty means footerNone.
+	Footer string
+	// Index is the path, below OutSubdir, of a document linking every
+	// generated document by package, see generateIndex.
+	Index string
+	// FrontMatter holds key=value pairs added to the YAML front matter
+	// documents of text formats start with, see frontMatter.
+	FrontMatter []string
+	// Vars holds the values of var parameters, passed to templates.
+	Vars map[string]string
+	// SidebarPositionStart is the sidebar_position of the first mdx
+	// document. Positions are omitted when it is zero.
+	SidebarPositionStart int
+	// OutputFile overrides the name of generated files. It is used as is
+	// when combining, and otherwise executed as a template with
+	// outputFileData, see outputFilename.
+	OutputFile string
+	// FieldLayout is how templates lay out the fields of a message, either
+	// fieldLayoutTable or fieldLayoutList. Empty means fieldLayoutTable.
+	FieldLayout string
+	// Content is which sections templates render: contentAll,
+	// contentServices or contentMessages. Empty means contentAll.
+	Content string
+	// GroupBy is how documents are organized: groupFile or groupPackage.
+	// Empty means groupFile.
+	GroupBy string
+	// Sort is the order of messages, enums, fields and enum values:
+	// sortDeclaration, sortName or sortNumber. Empty means sortDeclaration.
+	Sort string
+	// DotWKT is how the dot format draws the google.protobuf well-known
+	// types: dotWKTKeep, dotWKTCollapse or dotWKTOmit. Empty means
+	// dotWKTKeep.
+	DotWKT string
+	// Mermaid embeds a mermaid class diagram of the messages and enums of
+	// each file in markdown documents, see mermaidClassDiagram.
+	Mermaid bool
+	// WKT holds name=description pairs overriding wellKnownTypes, see
+	// wellKnown.
+	WKT []string
+	// ScalarNames is how templates name scalar types: scalarNamesProto or
+	// scalarNamesGeneric. Empty means scalarNamesProto. Scalars holds
+	// type=name pairs overriding either, see scalarType.
+	ScalarNames string
+	Scalars     []string
+	// Split is how documentation is split into files: splitFile,
+	// splitService or splitPage. Empty means splitFile.
+	Split string
+	// IncludePackages and ExcludePackages hold package patterns selecting
+	// the files to document, see includesPackage.
+	IncludePackages []string
+	ExcludePackages []string
+	// IncludeFiles and ExcludeFiles hold globs matched against the proto
+	// paths and packages of files, see skipReason and globRegexp.
+	IncludeFiles []string
+	ExcludeFiles []string
+	// SkipEmptyServices leaves files that define no services out, unless
+	// their types are referenced from files with services when combining or
+	// splitting, see skipEmptyServices.
+	SkipEmptyServices bool
+	// Verbose logs the files skipped by the options above to stderr.
+	Verbose bool
+
+	// Funcs is the set of functions available to templates: funcsFull or
+	// funcsMinimal. Empty means funcsFull.
+	Funcs string
+	// ListFuncs lists the functions available to templates on stderr.
+	ListFuncs bool
+	// IncludeDir is the directory include_file reads files from, see
+	// includeFile.
+	IncludeDir string
+
+	// HTMLStandalone leaves links to the pages of other files out of html
+	// pages, so that each page can be read on its own.
+	HTMLStandalone bool
+	// CSS is the path of the stylesheet inlined into html pages. The
+	// embedded templates/html.css is used when it is empty.
+	CSS string
+
+	// anchors holds the anchors of the current render pass.
+	anchors *anchorSet
+	// split holds the documents declarations are rendered into when
+	// splitting by service.
+	split *splitDocs
+	// meta describes the current generation, see generationMeta.
+	meta GenerationMeta
+	// docPaths holds the names of the documents generated per file by
+	// .proto path when Flat is set, so that links follow the flattening.
+	docPaths map[string]string
+	// rendering is the declaration last passed to a template function, which
+	// template errors report, see tracked.
+	rendering protoreflect.Descriptor
+	// documentFrontMatter holds the automatic front matter fields of the
+	// current document when front matter is configured, and
+	// wroteFrontMatter is set once its template wrote them with
+	// front_matter.
+	documentFrontMatter []frontMatterField
+	wroteFrontMatter    bool
+}
+
+// Field layouts, see GenOpts.FieldLayout.
+const (
+	// fieldLayoutTable renders a table row per field.
+	fieldLayoutTable = "table"
+	// fieldLayoutList renders a field per list entry with its full comment,
+	// for fields documented with several paragraphs.
+	fieldLayoutList = "list"
+)
+
+// RenderOptions are the options templates can honor, available as
+// .Options and from the render_options function.
+type RenderOptions struct {
+	// FieldLayout is "table" or "list".
+	FieldLayout string
+	// Content is "all", "services" or "messages".
+	Content string
+	// GroupBy is "file" or "package".
+	GroupBy string
+	// Standalone is set when pages must not link to other documents, see
+	// GenOpts.HTMLStandalone.
+	Standalone bool
+	// Split is "file", "service" or "page".
+	Split string
+	// Mermaid is set when markdown documents embed class diagrams, see
+	// GenOpts.Mermaid.
+	Mermaid bool
+}
+
+func (o *GenOpts) renderOptions() RenderOptions {
+	layout := o.FieldLayout
+	if layout == "" {
+		layout = fieldLayoutTable
+	}
+	content := o.Content
+	if content == "" {
+		content = contentAll
+	}
+	groupBy := o.GroupBy
+	if groupBy == "" {
+		groupBy = groupFile
+	}
+	split := o.Split
+	if split == "" {
+		split = splitFile
+	}
+	return RenderOptions{FieldLayout: layout, Content: content, GroupBy: groupBy, Standalone: o.HTMLStandalone, Split: split, Mermaid: o.Mermaid}
+}
+
+// defaultTitle is the title of documents covering several files when no
+// title is configured.
+const defaultTitle = "API Reference"
+
+// title returns the configured title, or defaultTitle.
+func (o *GenOpts) title() string {
+	if o.Title != "" {
+		return o.Title
+	}
+	return defaultTitle
+}
+
+// combinedFileName is the base name of the document generated when files are
+// combined.
+const combinedFileName = "api"
+
+// stdoutFileName is the name of the combined document with the stdout
+// option. protoc writes generated files below the directory of the
+// --apidocs_out flag, so with --apidocs_out=/dev it writes the document to
+// /dev/stdout, its own standard output. The plugin can't write to standard
+// output itself, which carries its response to protoc.
+const stdoutFileName = "stdout"
+
+// TemplateData is the data templates are executed with.
+type TemplateData struct {
+	// File is the file being rendered. It is embedded so templates can refer
+	// to its fields directly. File is nil when all files are combined into a
+	// single document.
+	*protogen.File
+	// Files holds every file rendered into the document. Combined documents
+	// hold the files ordered by package and path, or by package and then in
+	// the order protoc passed them when grouping by package.
+	Files []*protogen.File
+	// Packages groups Files by proto package, in the order of Files.
+	Packages []*PackageFiles
+	// Index is the position of File among the files being generated.
+	Index int
+	// FrontMatter holds the automatic and configured front matter fields by
+	// key, or nil when none are configured, see frontMatter.
+	FrontMatter map[string]string
+	// Title, Version and Description are the configured title, version and
+	// introduction of the documentation, or empty.
+	Title       string
+	Version     string
+	Description string
+	// Meta describes how the documentation was generated.
+	Meta GenerationMeta
+	// Options holds the options templates can honor.
+	Options RenderOptions
+	// Vars holds the values of var parameters, e.g. .Vars.base_url. Extra
+	// holds the same under the name of the template-data alias.
+	Vars  map[string]string
+	Extra map[string]string
+}
+
+// PackageFiles are the files of a proto package rendered into a document.
+type PackageFiles struct {
+	Name  protoreflect.FullName
+	Files []*protogen.File
+	// Services, Messages, Enums and Extensions hold the top-level
+	// declarations of Files, in the order of Files and then of their
+	// declaration, for templates rendering a chapter per package.
+	Services   []*protogen.Service
+	Messages   []*protogen.Message
+	Enums      []*protogen.Enum
+	Extensions []*protogen.Extension
+	// Depth is the number of enclosing packages among the packages of the
+	// document, e.g. 1 for com.acme.billing next to com.acme.
+	Depth int
+}
+
+// groupByPackage groups files by package, keeping the order of files within
+// a package and ordering packages by their first file.
+func groupByPackage(files []*protogen.File) []*PackageFiles {
+	var packages []*PackageFiles
+	byName := make(map[protoreflect.FullName]*PackageFiles)
+	for _, f := range files {
+		pkg, ok := byName[f.Desc.Package()]
+		if !ok {
+			pkg = &PackageFiles{Name: f.Desc.Package()}
+			byName[pkg.Name] = pkg
+			packages = append(packages, pkg)
+		}
+		pkg.Files = append(pkg.Files, f)
+		pkg.Services = append(pkg.Services, f.Services...)
+		pkg.Messages = append(pkg.Messages, f.Messages...)
+		pkg.Enums = append(pkg.Enums, f.Enums...)
+		pkg.Extensions = append(pkg.Extensions, f.Extensions...)
+	}
+	for _, pkg := range packages {
+		for parent := pkg.Name.Parent(); parent != ""; parent = parent.Parent() {
+			if _, ok := byName[parent]; ok {
+				pkg.Depth++
+			}
+		}
+	}
+	return packages
 }
 
 var formatFileSuffixes = map[string]string{
-	"markdown":      "md",
-	"hugo-markdown": "md",
+	"markdown":           "md",
+	"hugo-markdown":      "md",
+	"asciidoc":           "adoc",
+	"docbook":            "xml",
+	"confluence":         "wiki",
+	"confluence-storage": "xhtml",
+	"openapi":            "openapi.yaml",
+	"openapi-json":       "openapi.json",
+	"postman":            "postman_collection.json",
+	"man":                "7",
+	"latex":              "tex",
+	"slate":              "html.md",
+	"dokuwiki":           "txt",
+	"plantuml":           "puml",
+	"mermaid":            "mmd",
 }
 
-// generateFile generates a _ascii.pb.go file containing gRPC service definitions.
-func (o *GenOpts) generateFile(gen *protogen.Plugin, file *protogen.File) error {
-	suffix, ok := formatFileSuffixes[o.Format]
-	if !ok {
-		suffix = o.Format
+// fileSuffix returns the extension used for generated files: Ext when set,
+// the extension of TemplateFile without .tmpl, e.g. md for api.md.tmpl, and
+// otherwise the one of the configured format.
+func (o *GenOpts) fileSuffix() string {
+	if o.Ext != "" {
+		return strings.TrimPrefix(o.Ext, ".")
 	}
-	filename := file.GeneratedFilenamePrefix + "." + suffix
-	filename = strings.TrimPrefix(filename, o.TrimPrefix)
+	return o.outputSuffix()
+}
+
+// outputSuffix returns the extension of the output of the template: the
+// inner extension of template_file, e.g. "md" for api.md.tmpl, and
+// otherwise the one of the format. Unlike fileSuffix, it ignores Ext.
+func (o *GenOpts) outputSuffix() string {
+	if o.TemplateFile != "" {
+		name := filepath.Base(o.TemplateFile)
+		for _, ext := range []string{".tmpl", ".tpl"} {
+			name = strings.TrimSuffix(name, ext)
+		}
+		if ext := filepath.Ext(name); ext != "" {
+			return ext[1:]
+		}
+	}
+	if suffix, ok := formatFileSuffixes[o.Format]; ok {
+		return suffix
+	}
+	return o.Format
+}
+
+// formatRenderers holds the formats that are generated in Go code rather
+// than from a template.
+var formatRenderers = map[string]func(o *GenOpts, data *TemplateData, w io.Writer) error{
+	"json":         (*GenOpts).renderJSON,
+	"yaml":         (*GenOpts).renderYAML,
+	"openapi":      (*GenOpts).renderOpenAPI,
+	"openapi-json": (*GenOpts).renderOpenAPIJSON,
+	"postman":      (*GenOpts).renderPostman,
+	"csv":          (*GenOpts).renderCSV,
+	"dot":          (*GenOpts).renderDot,
+	"mermaid":      (*GenOpts).renderMermaid,
+}
+
+// generate generates documentation for every file protoc asked for.
+func (o *GenOpts) generate(gen *protogen.Plugin) error {
+	if layout := o.renderOptions().FieldLayout; layout != fieldLayoutTable && layout != fieldLayoutList {
+		return fmt.Errorf("invalid field_layout %q, want %q or %q", layout, fieldLayoutTable, fieldLayoutList)
+	}
+	switch o.renderOptions().Content {
+	case contentAll, contentServices, contentMessages:
+	default:
+		return fmt.Errorf("invalid content %q, want %q, %q or %q", o.Content, contentAll, contentServices, contentMessages)
+	}
+	switch o.renderOptions().GroupBy {
+	case groupFile:
+	case groupPackage:
+		if (o.Split != "" && o.Split != splitFile) || o.OutputFile != "" || o.MkdocsNav != "" || o.Index != "" {
+			return fmt.Errorf("group_by=%s cannot be used with split, output-file, mkdocs_nav or index", groupPackage)
+		}
+	default:
+		return fmt.Errorf("invalid group_by %q, want %q or %q", o.GroupBy, groupFile, groupPackage)
+	}
+	switch o.Sort {
+	case "", sortDeclaration, sortName, sortNumber:
+	default:
+		return fmt.Errorf("invalid sort %q, want %q, %q or %q", o.Sort, sortDeclaration, sortName, sortNumber)
+	}
+	if err := o.checkPackagePatterns(); err != nil {
+		return err
+	}
+	switch o.Funcs {
+	case "", funcsFull, funcsMinimal:
+	default:
+		return fmt.Errorf("invalid funcs %q, want %q or %q", o.Funcs, funcsFull, funcsMinimal)
+	}
+	if o.ListFuncs {
+		fmt.Fprintf(os.Stderr, "%s: functions of the %s templates: %s\n", pluginName, o.Format, strings.Join(o.funcNames(), ", "))
+	}
+	switch o.ScalarNames {
+	case "", scalarNamesProto, scalarNamesGeneric:
+	default:
+		return fmt.Errorf("invalid scalar_names %q, want %q or %q", o.ScalarNames, scalarNamesProto, scalarNamesGeneric)
+	}
+	switch o.DotWKT {
+	case "", dotWKTKeep, dotWKTCollapse, dotWKTOmit:
+	default:
+		return fmt.Errorf("invalid dot_wkt %q, want %q, %q or %q", o.DotWKT, dotWKTKeep, dotWKTCollapse, dotWKTOmit)
+	}
+	if o.LeftDelim != "" || o.RightDelim != "" {
+		if o.LeftDelim == "" || o.RightDelim == "" {
+			return fmt.Errorf("delims %q %q: both the left and the right delimiter are required", o.LeftDelim, o.RightDelim)
+		}
+		if o.LeftDelim == o.RightDelim {
+			return fmt.Errorf("delims %q %q: the left and right delimiters must differ", o.LeftDelim, o.RightDelim)
+		}
+	}
+	if o.TemplateFile != "" && len(o.TemplateDirs) > 0 {
+		return fmt.Errorf("template_file cannot be used with templates; put %s in a templates directory and name it after the format instead", filepath.Base(o.TemplateFile))
+	}
+	if strings.ContainsAny(o.Ext, `/\`) {
+		return fmt.Errorf("invalid ext %q, want an extension such as md", o.Ext)
+	}
+	if dir := path.Clean(o.OutSubdir); path.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+		return fmt.Errorf("out-subdir %q must be relative to the output directory", o.OutSubdir)
+	}
+	o.anchors = newAnchorSet(gen.Files)
+	o.meta = o.generationMeta(gen.Request)
+	var files []*protogen.File
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+		if reason := o.skipReason(f); reason != "" {
+			if o.Verbose {
+				fmt.Fprintf(os.Stderr, "%s: skipping %s: %s\n", pluginName, f.Desc.Path(), reason)
+			}
+			continue
+		}
+		documentGroups(f.Messages)
+		pruneExcluded(f)
+		o.sortDeclarations(f)
+		files = append(files, f)
+	}
+	if o.SkipEmptyServices {
+		files = o.skipEmptyServices(files, o.Combine || (o.Split != "" && o.Split != splitFile))
+	}
+	if o.Stdout {
+		if !o.Combine {
+			return fmt.Errorf("stdout requires combine, since protoc can only write a single document to its standard output")
+		}
+		if o.OutputFile != "" || o.OutSubdir != "" {
+			return fmt.Errorf("stdout cannot be used with output-file or out-subdir")
+		}
+	}
+	switch o.Split {
+	case "", splitFile:
+	case splitService, splitPage:
+		if o.Combine || o.OutputFile != "" || o.MkdocsNav != "" || o.Index != "" {
+			return fmt.Errorf("split=%s cannot be used with combine, output-file, mkdocs_nav or index", o.Split)
+		}
+		if o.Split == splitPage {
+			if o.renderOptions().Content != contentAll {
+				return fmt.Errorf("split=page cannot be used with content=%s", o.Content)
+			}
+			return o.generatePages(gen, files)
+		}
+		return o.generateSplit(gen, files)
+	default:
+		return fmt.Errorf("invalid split %q, want %q, %q or %q", o.Split, splitFile, splitService, splitPage)
+	}
+	if o.Combine {
+		if o.MkdocsNav != "" {
+			return fmt.Errorf("mkdocs_nav cannot be used with combine")
+		}
+		if o.Index != "" {
+			return fmt.Errorf("index cannot be used with combine")
+		}
+		filename := combinedFileName + "." + o.fileSuffix()
+		switch {
+		case o.Stdout:
+			filename = stdoutFileName
+		case o.OutputFile != "":
+			filename = o.OutputFile
+		}
+		filename = o.outPath(filename)
+		sort.SliceStable(files, func(i, j int) bool {
+			if a, b := files[i].Desc.Package(), files[j].Desc.Package(); a != b {
+				return a < b
+			}
+			// Chapters per package keep the order protoc passed the
+			// files in.
+			return o.GroupBy != groupPackage && files[i].Desc.Path() < files[j].Desc.Path()
+		})
+		return o.render(gen.NewGeneratedFile(filename, ""), filename, &TemplateData{Files: files, Packages: groupByPackage(files)})
+	}
+	if o.GroupBy == groupPackage {
+		return o.generatePackages(gen, files)
+	}
+	var pages []generatedPage
+	seen := make(map[string]*protogen.File)
+	for _, f := range files {
+		filename, err := o.fileDocPath(f)
+		if err != nil {
+			return err
+		}
+		if prev, ok := seen[filename]; ok {
+			return fmt.Errorf("%v and %v are both generated to %v", prev.Desc.Path(), f.Desc.Path(), filename)
+		}
+		seen[filename] = f
+		pages = append(pages, generatedPage{File: f, Filename: filename})
+	}
+	if o.Flat {
+		o.docPaths = make(map[string]string)
+		for _, p := range pages {
+			o.docPaths[p.File.Desc.Path()] = p.Filename
+		}
+		defer func() { o.docPaths = nil }()
+	}
+	for i, p := range pages {
+		if err := o.generateFile(gen, p.File, p.Filename, i); err != nil {
+			return err
+		}
+	}
+	if o.Index != "" {
+		if err := o.generateIndex(gen, pages); err != nil {
+			return err
+		}
+	}
+	if o.MkdocsNav != "" {
+		return o.generateMkdocsNav(gen, pages)
+	}
+	return nil
+}
+
+// generatedPage records the document generated for a file.
+type generatedPage struct {
+	File     *protogen.File
+	Filename string
+}
+
+// generateFile generates the documentation for file to filename. index is
+// the position of file among the files being generated.
+func (o *GenOpts) generateFile(gen *protogen.Plugin, file *protogen.File, filename string, index int) error {
 	g := gen.NewGeneratedFile(filename, file.GoImportPath)
-	if err := o.renderTemplate(file, g); err != nil {
+	files := []*protogen.File{file}
+	return o.render(g, filename, &TemplateData{File: file, Files: files, Packages: groupByPackage(files), Index: index})
+}
+
+// fileDocPath returns the name the documentation of file is generated to.
+func (o *GenOpts) fileDocPath(file *protogen.File) (string, error) {
+	filename := file.GeneratedFilenamePrefix + "." + o.fileSuffix()
+	if o.OutputFile != "" {
+		var err error
+		if filename, err = o.outputFilename(file.Desc); err != nil {
+			return "", err
+		}
+	}
+	return o.docPath(filename), nil
+}
+
+// docPath returns the name a document named filename is generated to:
+// TrimPrefix is removed, slashes are replaced with dots when Flat is set,
+// and the result is placed below OutSubdir.
+func (o *GenOpts) docPath(filename string) string {
+	filename = strings.TrimPrefix(filename, o.TrimPrefix)
+	if o.Flat {
+		filename = strings.ReplaceAll(strings.TrimPrefix(filename, "/"), "/", ".")
+	}
+	return o.outPath(filename)
+}
+
+// outPath returns the name filename is generated to, below OutSubdir.
+// protoc writes generated files relative to the directory of the
+// --apidocs_out flag.
+func (o *GenOpts) outPath(filename string) string {
+	if o.OutSubdir == "" {
+		return filename
+	}
+	return path.Join(o.OutSubdir, filename)
+}
+
+// outputFileData is the data the OutputFile template is executed with.
+type outputFileData struct {
+	// Package is the proto package of the file, e.g. "com.example.booking".
+	Package string
+	// Dir is the directory of the .proto file, e.g. "example1".
+	Dir string
+	// Base is the name of the .proto file without its extension, e.g.
+	// "booking".
+	Base string
+	// Ext is the file suffix of the configured format, e.g. "md".
+	Ext string
+}
+
+// outputFilename executes the OutputFile template for fd.
+func (o *GenOpts) outputFilename(fd protoreflect.FileDescriptor) (string, error) {
+	t, err := template.New("output-file").Parse(o.OutputFile)
+	if err != nil {
+		return "", fmt.Errorf("invalid output-file: %w", err)
+	}
+	var buf strings.Builder
+	err = t.Execute(&buf, outputFileData{
+		Package: string(fd.Package()),
+		Dir:     filepath.Dir(fd.Path()),
+		Base:    strings.TrimSuffix(filepath.Base(fd.Path()), filepath.Ext(fd.Path())),
+		Ext:     o.fileSuffix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid output-file: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// formatRenderer returns the renderer of formats generated in Go code, and
+// false for formats rendered from a template.
+func (o *GenOpts) formatRenderer() (func(o *GenOpts, data *TemplateData, w io.Writer) error, bool) {
+	if o.TemplateFile != "" {
+		return nil, false
+	}
+	r, ok := formatRenderers[o.Format]
+	return r, ok
+}
+
+func (o *GenOpts) render(w io.Writer, filename string, data *TemplateData) error {
+	render := (*GenOpts).renderTemplate
+	if r, ok := o.formatRenderer(); ok {
+		render = r
+	}
+	data.Options = o.renderOptions()
+	data.Title, data.Version, data.Description = o.Title, o.Version, o.Description
+	data.Meta = o.fileMeta(data.File)
+	data.Vars, data.Extra = o.Vars, o.Vars
+	o.documentFrontMatter, o.wroteFrontMatter = nil, false
+	if _, ok := o.formatRenderer(); ok || o.isHTML() || len(o.FrontMatter) == 0 {
+		data.FrontMatter = nil
+		if err := render(o, data, w); err != nil {
+			return fmt.Errorf("issue generating %v: %w", filename, err)
+		}
+		return nil
+	}
+	// Documents of text formats start with front matter, which templates
+	// may write themselves with front_matter.
+	o.documentFrontMatter = o.automaticFrontMatter(data)
+	data.FrontMatter = o.frontMatterFields()
+	var buf strings.Builder
+	if err := render(o, data, &buf); err != nil {
 		return fmt.Errorf("issue generating %v: %w", filename, err)
 	}
-	return nil
+	if !o.wroteFrontMatter {
+		frontMatter, err := o.frontMatter()
+		if err != nil {
+			return fmt.Errorf("issue generating %v: %w", filename, err)
+		}
+		if _, err := fmt.Fprintln(w, frontMatter); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, buf.String())
+	return err
 }
 
 func (o *GenOpts) relPath(t1, t2 protoreflect.Descriptor) string {
+	if o.split != nil {
+		if path, ok := o.split.relPath(t2); ok {
+			return path
+		}
+	}
 	path := ""
 	cpf := filepath.Base(fmt.Sprint(t1.ParentFile().Path()))
 	rpf := filepath.Base(fmt.Sprint(t2.ParentFile().Path()))
-	if cpf != rpf {
+	if to, ok := o.docPaths[t2.ParentFile().Path()]; ok && t1.ParentFile().Path() != t2.ParentFile().Path() {
+		// Flattened documents share a directory, so link to the name the
+		// referenced file's document was given.
+		path, _ = filepath.Rel(filepath.Dir(o.docPaths[t1.ParentFile().Path()]), to)
+	} else if cpf != rpf && !o.Combine && o.OutputFile != "" {
+		// Documents are named by the output-file template, so link to the
+		// name it gives the referenced file.
+		from, err1 := o.outputFilename(t1.ParentFile())
+		to, err2 := o.outputFilename(t2.ParentFile())
+		if err1 == nil && err2 == nil {
+			path, _ = filepath.Rel(filepath.Dir(from), to)
+		}
+	} else if cpf != rpf && !o.Combine {
 		path, _ = filepath.Rel(cpf, rpf)
 		path = strings.TrimSuffix(path, filepath.Ext(path))
 		path = strings.TrimPrefix(path, ".")
-		path = fmt.Sprintf("%s.%s", path, formatFileSuffixes[o.Format])
+		path = fmt.Sprintf("%s.%s", path, o.fileSuffix())
 	}
 	return path
 }
 
+// longName returns the name of d qualified by its enclosing messages, but not
+// by its package, e.g. "Outer.Middle.Inner".
 func longName(d protoreflect.Descriptor) string {
-	p := d.Parent()
-	if p != nil && p.Parent() != nil {
-		return fmt.Sprintf("%v.%v", p.Name(), d.Name())
+	name := string(d.Name())
+	for p := d.Parent(); p != nil; p = p.Parent() {
+		if _, ok := p.(protoreflect.FileDescriptor); ok {
+			break
+		}
+		name = fmt.Sprintf("%v.%v", p.Name(), name)
+	}
+	return name
+}
+
+func fieldType(f *protogen.Field) string {
+	if f.Message != nil {
+		return longName(f.Message.Desc)
+	}
+	if f.Enum != nil {
+		return longName(f.Enum.Desc)
+	}
+	return fmt.Sprint(f.Desc.Kind())
+}
+
+// mapType returns the type of a map field as it would be declared, e.g.
+// "map<string, Vehicle.Category>".
+func mapType(f *protogen.Field) string {
+	if !f.Desc.IsMap() {
+		return fieldType(f)
+	}
+	return fmt.Sprintf("map<%s, %s>", fieldType(f.Message.Fields[0]), fieldType(f.Message.Fields[1]))
+}
+
+func fullFieldType(f *protogen.Field) string {
+	if f.Message != nil {
+		return fmt.Sprint(f.Message.Desc.FullName())
+	}
+	if f.Enum != nil {
+		return fmt.Sprint(f.Enum.Desc.FullName())
+	}
+	return fmt.Sprint(f.Desc.Kind())
+}
+
+func description(s interface{}) string {
+	val := strings.TrimLeft(fmt.Sprint(s), "*/\n ")
+	if strings.HasPrefix(val, excludeMarker) {
+		return ""
+	}
+	return commentPattern.ReplaceAllString(val, "\n")
+}
+
+// commentSet returns the comments of d, which is a protogen.CommentSet or a
+// declaration with one, such as a message, field or method.
+func commentSet(d interface{}) (protogen.CommentSet, error) {
+	switch d := d.(type) {
+	case protogen.CommentSet:
+		return d, nil
+	case *protogen.Service:
+		return d.Comments, nil
+	case *protogen.Method:
+		return d.Comments, nil
+	case *protogen.Message:
+		return d.Comments, nil
+	case *protogen.Field:
+		return d.Comments, nil
+	case *protogen.Oneof:
+		return d.Comments, nil
+	case *protogen.Enum:
+		return d.Comments, nil
+	case *protogen.EnumValue:
+		return d.Comments, nil
+	}
+	return protogen.CommentSet{}, fmt.Errorf("%T has no comments", d)
+}
+
+// leadingComment returns the comment preceding a declaration, passed through
+// description and trimmed.
+func leadingComment(d interface{}) (string, error) {
+	c, err := commentSet(d)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(description(c.Leading)), nil
+}
+
+// trailingComment returns the comment following a declaration on the same
+// line or the next one, passed through description and trimmed. It is empty
+// for excluded declarations.
+func trailingComment(d interface{}) (string, error) {
+	c, err := commentSet(d)
+	if err != nil || isExcluded(c) {
+		return "", err
+	}
+	return strings.TrimSpace(description(c.Trailing)), nil
+}
+
+// detachedComments returns the comments separated from a declaration by a
+// blank line, passed through description and trimmed. Excluded and empty
+// comments are left out.
+func detachedComments(d interface{}) ([]string, error) {
+	c, err := commentSet(d)
+	if err != nil {
+		return nil, err
+	}
+	var comments []string
+	for _, detached := range c.LeadingDetached {
+		if s := strings.TrimSpace(description(detached)); s != "" {
+			comments = append(comments, s)
+		}
+	}
+	return comments, nil
+}
+
+// fieldLabel returns the label a field was declared with: "repeated",
+// "required" or "optional", or "map" for map fields, whose repeated entries
+// are an implementation detail. Singular proto3 fields without the optional
+// keyword have no label.
+func fieldLabel(f *protogen.Field) string {
+	switch {
+	case f.Desc.IsMap():
+		return "map"
+	case f.Desc.Cardinality() == protoreflect.Repeated:
+		return "repeated"
+	case f.Desc.Cardinality() == protoreflect.Required:
+		return "required"
+	case f.Desc.HasOptionalKeyword():
+		return "optional"
+	}
+	return ""
+}
+
+// hasPresence reports whether f tells being unset from being set to its zero
+// value: proto3 optional fields, proto2 singular fields, message fields and
+// the members of oneofs.
+func hasPresence(f *protogen.Field) bool {
+	return f.Desc.HasPresence()
+}
+
+// reservedRanges returns the field numbers reserved by a message as they
+// are declared, e.g. "5", "10 to 12" or "1000 to max".
+func reservedRanges(m *protogen.Message) []string {
+	var ranges []string
+	rs := m.Desc.ReservedRanges()
+	for i := 0; i < rs.Len(); i++ {
+		r := rs.Get(i)
+		start, end := r[0], r[1]-1 // The end of ranges is exclusive.
+		switch {
+		case start == end:
+			ranges = append(ranges, strconv.Itoa(int(start)))
+		case end == protowire.MaxValidNumber:
+			ranges = append(ranges, fmt.Sprintf("%d to max", start))
+		default:
+			ranges = append(ranges, fmt.Sprintf("%d to %d", start, end))
+		}
+	}
+	return ranges
+}
+
+// reservedNames returns the field names reserved by a message.
+func reservedNames(m *protogen.Message) []string {
+	var names []string
+	ns := m.Desc.ReservedNames()
+	for i := 0; i < ns.Len(); i++ {
+		names = append(names, string(ns.Get(i)))
+	}
+	return names
+}
+
+// defaultValue returns the explicit default of a proto2 field as it would be
+// written in the .proto file: strings and bytes are quoted and enum defaults
+// are given by name. Fields without a default, including every proto3 field,
+// return "".
+func defaultValue(f *protogen.Field) string {
+	if !f.Desc.HasDefault() {
+		return ""
+	}
+	v := f.Desc.Default()
+	switch f.Desc.Kind() {
+	case protoreflect.EnumKind:
+		return string(f.Desc.DefaultEnumValue().Name())
+	case protoreflect.StringKind:
+		return strconv.Quote(v.String())
+	case protoreflect.BytesKind:
+		return strconv.Quote(string(v.Bytes()))
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// oneofs returns the oneofs declared in msg. The synthetic oneofs protoc
+// creates for proto3 optional fields are left out, their fields are
+// documented like any other field.
+func oneofs(msg *protogen.Message) []*protogen.Oneof {
+	var declared []*protogen.Oneof
+	for _, o := range msg.Oneofs {
+		if !o.Desc.IsSynthetic() {
+			declared = append(declared, o)
+		}
+	}
+	return declared
+}
+
+// isGroup reports whether d is a proto2 group field, or the message such a
+// field declares. The markdown and html formats document the fields of a
+// group inline after the group field, named by fieldPath, instead of in a
+// section of their own.
+func isGroup(d interface{}) bool {
+	switch d := d.(type) {
+	case *protogen.Field:
+		return d.Desc.Kind() == protoreflect.GroupKind
+	case *protogen.Message:
+		return groupField(d.Desc) != nil
 	}
-	return fmt.Sprint(d.Name())
+	return false
 }
 
-func anchor(str interface{}) string {
-	return specialCharsPattern.ReplaceAllString(strings.ReplaceAll(fmt.Sprint(str), "/", "_"), "-")
+// groupField returns the group field declaring md, or nil if md is not a
+// group.
+func groupField(md protoreflect.MessageDescriptor) protoreflect.FieldDescriptor {
+	parent, ok := md.Parent().(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil
+	}
+	fields := parent.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if f := fields.Get(i); f.Kind() == protoreflect.GroupKind && f.Message() == md {
+			return f
+		}
+	}
+	return nil
+}
+
+// fieldPath returns the name of f prefixed with the names of the group
+// fields it is nested in, e.g. "result.url", or its name outside groups.
+func fieldPath(f *protogen.Field) string {
+	path := string(f.Desc.Name())
+	for g := groupField(f.Desc.ContainingMessage()); g != nil; g = groupField(g.ContainingMessage()) {
+		path = string(g.Name()) + "." + path
+	}
+	return path
+}
+
+// documentGroups gives the group fields of file without comments of their
+// own the comments of their group. protoc attaches the comments preceding a
+// group to the message it declares rather than to the field.
+func documentGroups(msgs []*protogen.Message) {
+	for _, m := range msgs {
+		for _, f := range m.Fields {
+			if isGroup(f) && f.Comments.Leading == "" && f.Comments.Trailing == "" {
+				f.Comments = f.Message.Comments
+			}
+		}
+		documentGroups(m.Messages)
+	}
+}
+
+// nestedMessages returns the messages declared in msg. The map entry
+// messages protoc synthesizes for map fields are left out, map fields are
+// documented with their key and value types instead. Templates recurse into
+// the result to document nested messages; the recursion follows declarations
+// rather than field types, so self-referential messages end it too.
+func nestedMessages(msg *protogen.Message) []*protogen.Message {
+	var nested []*protogen.Message
+	for _, m := range msg.Messages {
+		if !m.Desc.IsMapEntry() {
+			nested = append(nested, m)
+		}
+	}
+	return nested
+}
+
+// messageRef is a field of a message holding another message or an enum,
+// see messageRefs.
+type messageRef struct {
+	// Field is the referencing field.
+	Field *protogen.Field
+	// Target is the full name of the referenced message or enum. Map fields
+	// reference their value type.
+	Target protoreflect.FullName
+	// Enum is set when Target is an enum.
+	Enum bool
+	// Many is set when the field holds any number of values, i.e. it is
+	// repeated or a map.
+	Many bool
+}
+
+// messageRefs returns the references from the fields of msg to messages and
+// enums, in field order. Fields of scalar types, maps with scalar values,
+// and fields of excluded types reference nothing.
+func messageRefs(msg *protogen.Message) []messageRef {
+	var refs []messageRef
+	for _, f := range msg.Fields {
+		typ := f
+		if f.Desc.IsMap() {
+			typ = f.Message.Fields[1]
+		}
+		ref := messageRef{Field: f, Many: f.Desc.IsList() || f.Desc.IsMap()}
+		switch {
+		case typ.Message != nil:
+			ref.Target = typ.Message.Desc.FullName()
+		case typ.Enum != nil:
+			ref.Target, ref.Enum = typ.Enum.Desc.FullName(), true
+		default:
+			continue
+		}
+		if excludedDeclaration(typ.Desc.Message()) || excludedDeclaration(typ.Desc.Enum()) {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// oneofFields returns the member fields of o in declaration order.
+func oneofFields(o *protogen.Oneof) []*protogen.Field {
+	return o.Fields
+}
+
+// inRealOneof reports whether f is a member of a oneof declared in the
+// .proto file rather than of the synthetic oneof of a proto3 optional field.
+func inRealOneof(f *protogen.Field) bool {
+	return f.Oneof != nil && !f.Oneof.Desc.IsSynthetic()
+}
+
+// oneofName returns the name of the oneof f is a member of, or "" when it
+// isn't a member of a oneof declared in the .proto file.
+func oneofName(f *protogen.Field) string {
+	if !inRealOneof(f) {
+		return ""
+	}
+	return string(f.Oneof.Desc.Name())
+}
+
+// streamingKind describes how messages are exchanged by m: "unary",
+// "client streaming", "server streaming" or "bidirectional streaming".
+func streamingKind(m *protogen.Method) string {
+	switch {
+	case m.Desc.IsStreamingClient() && m.Desc.IsStreamingServer():
+		return "bidirectional streaming"
+	case m.Desc.IsStreamingClient():
+		return "client streaming"
+	case m.Desc.IsStreamingServer():
+		return "server streaming"
+	}
+	return "unary"
+}
+
+// isDeprecated reports whether the deprecated option is set on d.
+func isDeprecated(d protoreflect.Descriptor) bool {
+	opts, ok := d.Options().(interface{ GetDeprecated() bool })
+	return ok && opts.GetDeprecated()
+}
+
+// Sets of template functions, see GenOpts.Funcs.
+const (
+	// funcsFull makes every sprig function available.
+	funcsFull = "full"
+	// funcsMinimal leaves out the sprig functions that read the environment
+	// or the network, which templates rendering untrusted protos, e.g. in
+	// CI, have no use for.
+	funcsMinimal = "minimal"
+)
+
+// unsafeSprigFuncs are the sprig functions left out by funcsMinimal.
+var unsafeSprigFuncs = []string{"env", "expandenv", "getHostByName"}
+
+// funcMap returns the functions available to templates: those of
+// templateFuncMap, tracked for errors, and sprig's, in their html/template
+// variant when html is set, as limited by o.Funcs.
+func (o *GenOpts) funcMap(html bool) template.FuncMap {
+	funcs := o.templateFuncMap()
+	for name, fn := range funcs {
+		funcs[name] = o.tracked(fn)
+	}
+	sprigFuncs := sprig.TxtFuncMap()
+	if html {
+		sprigFuncs = template.FuncMap(sprig.HtmlFuncMap())
+	}
+	for name, fn := range sprigFuncs {
+		funcs[name] = fn
+	}
+	if o.Funcs == funcsMinimal {
+		for _, name := range unsafeSprigFuncs {
+			delete(funcs, name)
+		}
+	}
+	return funcs
+}
+
+// funcNames returns the sorted names of the functions available to the
+// templates of the format.
+func (o *GenOpts) funcNames() []string {
+	var names []string
+	for name := range o.funcMap(o.isHTML()) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 func (o *GenOpts) templateFuncMap() template.FuncMap {
 	return map[string]interface{}{
-		"anchor":    anchor,
-		"long_name": longName,
-		"field_type": func(f *protogen.Field) string {
-			if f.Message != nil {
-				return longName(f.Message.Desc)
-			}
-			if f.Enum != nil {
-				return longName(f.Enum.Desc)
-			}
-			return fmt.Sprint(f.Desc.Kind())
+		"anchor":          o.anchor,
+		"field_anchor":    o.fieldAnchor,
+		"page_link":       o.pageLink,
+		"index_link":      o.indexLink,
+		"long_name":       longName,
+		"field_type":      o.fieldType,
+		"full_field_type": fullFieldType,
+		"is_map": func(f *protogen.Field) bool {
+			return f.Desc.IsMap()
 		},
-		"full_field_type": func(f *protogen.Field) string {
-			if f.Message != nil {
-				return fmt.Sprint(f.Message.Desc.FullName())
-			}
-			if f.Enum != nil {
-				return fmt.Sprint(f.Enum.Desc.FullName())
-			}
-			return fmt.Sprint(f.Desc.Kind())
+		"map_type":       o.mapType,
+		"map_key_type":   o.mapKeyType,
+		"map_value_type": o.mapValueType,
+		"label":          fieldLabel,
+		"has_presence":   hasPresence,
+		"field_number": func(f *protogen.Field) int {
+			return int(f.Desc.Number())
+		},
+		"reserved_ranges": reservedRanges,
+		"reserved_names":  reservedNames,
+		"json_name": func(f *protogen.Field) string {
+			return f.Desc.JSONName()
+		},
+		"default_value":   defaultValue,
+		"enum_values":     enumValues,
+		"is_excluded":     isExcluded,
+		"http_rules":      httpRules,
+		"json_example":    jsonExample,
+		"is_deprecated":   isDeprecated,
+		"custom_options":  customOptions,
+		"oneofs":          oneofs,
+		"nested_messages": nestedMessages,
+		"is_group":        isGroup,
+		"field_path":      fieldPath,
+		"proto_snippet":   protoSnippet,
+		"message_refs":    messageRefs,
+		"oneof_fields":    oneofFields,
+		"in_real_oneof":   inRealOneof,
+		"is_oneof_member": inRealOneof,
+		"oneof_name":      oneofName,
+		"is_client_streaming": func(m *protogen.Method) bool {
+			return m.Desc.IsStreamingClient()
+		},
+		"is_server_streaming": func(m *protogen.Method) bool {
+			return m.Desc.IsStreamingServer()
 		},
+		"streaming_kind":  streamingKind,
+		"method_messages": methodMessages,
+
+		"method_input":         methodInput,
+		"method_output":        methodOutput,
+		"method_input_anchor":  o.methodInputAnchor,
+		"method_output_anchor": o.methodOutputAnchor,
 		"is_primitive": func(f *protogen.Field) bool {
 			// TODO: consider oneof, enum, ...
 			k := f.Desc.Kind()
 			nonPrim := k == protoreflect.EnumKind || k == protoreflect.MessageKind || k == protoreflect.GroupKind
 			return !nonPrim
 		},
+		"validation_rules":     validationRules,
+		"has_validation_rules": hasValidationRules,
+		"referenced_types":     referencedTypes,
+		"scalar_types": func() []ScalarType {
+			return scalarTypes
+		},
+		"include_file": o.includeFile,
+		"scalar_type":  o.scalarType,
 		"message_type": func(f *protogen.Message) string {
 			if f == nil {
 				return "(none)"
@@ -135,6 +1380,9 @@ func (o *GenOpts) templateFuncMap() template.FuncMap {
 		"full_message_type": func(f *protogen.Message) string {
 			return fmt.Sprint(f.Desc.FullName())
 		},
+		"is_wkt":      o.isWKT,
+		"wkt_display": o.wktDisplay,
+		"wkt_link":    wktLink,
 		"is_google_type": func(f *protogen.Field) bool {
 			if f.Message != nil {
 				return strings.HasPrefix(string(f.Message.Desc.FullName()), "google.")
@@ -147,20 +1395,30 @@ func (o *GenOpts) templateFuncMap() template.FuncMap {
 		"type_link": func(f *protogen.Field) string {
 			var t1, t2 protoreflect.Descriptor
 			t1 = f.Desc
+			if f.Desc.IsMap() {
+				f = f.Message.Fields[1]
+			}
 			if f.Message != nil {
 				t2 = f.Message.Desc
 			}
 			if f.Enum != nil {
 				t2 = f.Enum.Desc
 			}
+			// Excluded types have no section to link to.
+			if t2 == nil || excludedDeclaration(t2) {
+				return ""
+			}
 			if strings.HasPrefix(string(t2.FullName()), "google.") {
 				return string(t2.FullName())
 			}
 			fn := o.relPath(t1, t2)
-			typ := anchor(fmt.Sprint(t2.FullName()))
+			typ := o.anchor(fmt.Sprint(t2.FullName()))
 			return fmt.Sprintf(`%s#%s`, fn, typ)
 		},
 		"hugo_type_link": func(f *protogen.Field) string {
+			if (f.Message != nil && excludedDeclaration(f.Message.Desc)) || (f.Enum != nil && excludedDeclaration(f.Enum.Desc)) {
+				return ""
+			}
 			// exclude google types:
 
 			if f.Message != nil {
@@ -170,54 +1428,291 @@ func (o *GenOpts) templateFuncMap() template.FuncMap {
 				fn := fmt.Sprint(f.Message.Desc.ParentFile().Path())
 				fn = filepath.Base(fn)
 				fn = strings.TrimSuffix(fn, filepath.Ext(fn))
-				typ := anchor(fmt.Sprint(f.Message.Desc.FullName()))
+				typ := o.anchor(fmt.Sprint(f.Message.Desc.FullName()))
 				return fmt.Sprintf(`{{< relref "%s#%s" >}}`, fn, typ)
 			}
 			if f.Enum != nil {
 				fn := fmt.Sprint(f.Enum.Desc.ParentFile().Path())
 				fn = filepath.Base(fn)
 				fn = strings.TrimSuffix(fn, filepath.Ext(fn))
-				typ := anchor(fmt.Sprint(f.Enum.Desc.FullName()))
+				typ := o.anchor(fmt.Sprint(f.Enum.Desc.FullName()))
 				return fmt.Sprintf(`{{< relref "%s#%s" >}}`, fn, typ)
 			}
-			return fmt.Sprintf(`#%s`, anchor(f.Desc.FullName()))
+			return fmt.Sprintf(`#%s`, o.anchor(f.Desc.FullName()))
 		},
-		"description": func(s interface{}) string {
-			val := strings.TrimLeft(fmt.Sprint(s), "*/\n ")
-			if strings.HasPrefix(val, "@exclude") {
-				return ""
-			}
-			commentRe := regexp.MustCompile("\n// ?")
-			return commentRe.ReplaceAllString(val, "\n")
-		},
-		"p":    pFilter,
-		"para": paraFilter,
-		"nobr": nobrFilter,
+		"description": description,
+		"p":           pFilter,
+		"para":        paraFilter,
+		"nobr":        nobrFilter,
+		"inline":      inlineFilter,
+
+		"leading_comment":   leadingComment,
+		"trailing_comment":  trailingComment,
+		"detached_comments": detachedComments,
+
+		"adoc_escape":       adocEscapeFilter,
+		"adoc_para":         adocParaFilter,
+		"confluence_escape": confluenceEscapeFilter,
+		"confluence_para":   confluenceParaFilter,
+		"storage_code":      storageCodeFilter,
+		"storage_para":      storageParaFilter,
+		"dokuwiki_escape":   dokuwikiEscapeFilter,
+		"dokuwiki_id":       dokuwikiID,
+		"dokuwiki_para":     dokuwikiParaFilter,
+		"man_escape":        manEscapeFilter,
+		"man_para":          manParaFilter,
+		"md_escape":         mdEscapeFilter,
+		"mdx_escape":        mdxEscapeFilter,
+		"plantuml_id":       plantumlID,
+		"mermaid_diagram":   o.mermaidDiagramFunc,
+		"sidebar_position":  o.sidebarPosition,
+		"front_matter":      o.frontMatter,
+		"render_options":    o.renderOptions,
+		"stylesheet":        o.stylesheet,
+		"rst_para":          rstParaFilter,
+		"rst_title":         rstTitle,
+		"tex_escape":        texEscapeFilter,
+		"tex_para":          texParaFilter,
+		"textile_escape":    textileEscapeFilter,
+		"textile_para":      textileParaFilter,
+		"xml_escape":        xmlEscapeFilter,
 	}
 }
 
 //go:embed templates/*
 var defaultTemplates embed.FS
 
+// templatePartials matches the files of shared template definitions, which
+// are parsed along with the template of every format.
+const templatePartials = "partials/*.tmpl"
+
+// templateFiles returns the files parsed for the template name in each
+// layer, from the embedded templates up to the first of o.TemplateDirs: the
+// shared partials, the partials in the directory dir, e.g. markdown/*.tmpl
+// for the markdown format, and the template itself if the layer has it.
+// Later files override the definitions of earlier ones, so a templates
+// directory can redefine a single partial of the embedded templates, and a
+// template can still redefine a partial. A file is read from the first layer
+// that has it only. An empty name returns the partials alone.
+func templateFiles(layers []templateLayer, name, dir string) ([]templateFile, error) {
+	patterns := []string{templatePartials}
+	if dir != "" {
+		patterns = append(patterns, path.Join(dir, "*.tmpl"))
+	}
+	var (
+		files []templateFile
+		found bool
+	)
+	for i := len(layers) - 1; i >= 0; i-- {
+		l := &layers[i]
+		var names []string
+		for _, pattern := range patterns {
+			matches, err := fs.Glob(l.fsys, pattern)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, matches...)
+		}
+		if name != "" {
+			if _, err := fs.Stat(l.fsys, name); err == nil {
+				names = append(names, name)
+				found = true
+			}
+		}
+		for _, n := range names {
+			if !hasTemplate(layers[:i], n) {
+				files = append(files, templateFile{layer: l, name: n})
+			}
+		}
+	}
+	if name != "" && !found {
+		return nil, fmt.Errorf("template %s: %w", name, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist})
+	}
+	return files, nil
+}
+
+// hasTemplate reports whether any of layers has the file name.
+func hasTemplate(layers []templateLayer, name string) bool {
+	for _, l := range layers {
+		if _, err := fs.Stat(l.fsys, name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTemplateFiles reads files and passes their contents to parse, along
+// with whether they are custom templates rather than embedded ones. Errors
+// name the file that failed, including the templates directory it was read
+// from, and list every file parsed with it.
+func parseTemplateFiles(files []templateFile, parse func(name, text string, custom bool) error) error {
+	for _, f := range files {
+		b, err := fs.ReadFile(f.layer.fsys, f.name)
+		if err == nil {
+			err = parse(f.name, string(b), f.layer.dir != "")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w (parsed with %s)", f.source(), err, sourceList(files))
+		}
+	}
+	return nil
+}
+
+// delims returns the action delimiters of custom templates, or of the
+// embedded ones, for Template.Delims, which takes empty delimiters as the
+// defaults.
+func (o *GenOpts) delims(custom bool) (left, right string) {
+	if !custom {
+		return "", ""
+	}
+	return o.LeftDelim, o.RightDelim
+}
+
+// templateLayers returns the templates of o.TemplateDirs followed by the
+// embedded ones, in order of precedence.
+func (o *GenOpts) templateLayers() ([]templateLayer, error) {
+	var layers []templateLayer
+	for _, dir := range o.TemplateDirs {
+		// Relative directories are resolved against the working directory
+		// of protoc, which is named in errors to make them clear.
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if fi, err := os.Stat(abs); err != nil {
+			return nil, fmt.Errorf("templates directory: %w", err)
+		} else if !fi.IsDir() {
+			return nil, fmt.Errorf("templates directory %s is not a directory", abs)
+		}
+		layers = append(layers, templateLayer{fsys: os.DirFS(abs), dir: dir})
+	}
+	defaults, err := fs.Sub(defaultTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	return append(layers, templateLayer{fsys: defaults}), nil
+}
+
+// getTemplateFS returns the templates of o.TemplateDirs layered over the
+// embedded ones, so a directory only needs the templates it overrides.
 func (o *GenOpts) getTemplateFS() (fs.FS, error) {
-	if o.TemplateDir == "" {
-		return fs.Sub(defaultTemplates, "templates")
+	layers, err := o.templateLayers()
+	if err != nil {
+		return nil, err
+	}
+	var tFS layeredFS
+	for _, l := range layers {
+		tFS = append(tFS, l.fsys)
 	}
-	tFS := os.DirFS(o.TemplateDir)
-	return fs.Sub(tFS, o.TemplateDir)
+	return tFS, nil
 }
 
-func (o *GenOpts) renderTemplate(file *protogen.File, g *protogen.GeneratedFile) error {
+// stylesheet returns the stylesheet inlined into html pages, read from
+// o.CSS or else html.css of the templates.
+func (o *GenOpts) stylesheet() (htmltemplate.CSS, error) {
+	if o.CSS != "" {
+		b, err := os.ReadFile(o.CSS)
+		return htmltemplate.CSS(b), err
+	}
 	tFS, err := o.getTemplateFS()
 	if err != nil {
-		return err
+		return "", err
+	}
+	b, err := fs.ReadFile(tFS, "html.css")
+	return htmltemplate.CSS(b), err
+}
+
+// isHTML reports whether the template renders HTML, in which case it is
+// rendered with html/template so comment text is escaped. The format or the
+// name of template_file decide, not the extension set with Ext, which only
+// names the generated files.
+func (o *GenOpts) isHTML() bool {
+	return o.outputSuffix() == "html"
+}
+
+type templateExecutor interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+	DefinedTemplates() string
+}
+
+func (o *GenOpts) parseTemplate() (templateExecutor, error) {
+	layers, err := o.templateLayers()
+	if err != nil {
+		return nil, err
+	}
+	var files []templateFile
+	if o.TemplateFile != "" {
+		// The file replaces the template of the format, so only the
+		// partials are parsed from the embedded templates.
+		files, err = templateFiles(layers, "", o.Format)
+	} else {
+		name := fmt.Sprintf("%v.tmpl", o.Format)
+		files, err = templateFiles(layers, name, o.Format)
+		if errors.Is(err, fs.ErrNotExist) && len(o.TemplateDirs) > 0 {
+			return nil, o.templateNotFound(name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var (
+		t     templateExecutor
+		parse func(name, text string, custom bool) error
+	)
+	if o.isHTML() {
+		ht := htmltemplate.New("file.tmpl").Funcs(htmltemplate.FuncMap(o.funcMap(true)))
+		t, parse = ht, func(name, text string, custom bool) error {
+			_, err := ht.New(name).Delims(o.delims(custom)).Parse(text)
+			return err
+		}
+	} else {
+		tt := template.New("file.tmpl").Funcs(o.funcMap(false))
+		t, parse = tt, func(name, text string, custom bool) error {
+			_, err := tt.New(name).Delims(o.delims(custom)).Parse(text)
+			return err
+		}
 	}
-	t := template.New("file.tmpl").Funcs(o.templateFuncMap()).Funcs(sprig.TxtFuncMap())
-	t, err = t.ParseFS(tFS, fmt.Sprintf("%v.tmpl", o.Format))
+	if err := parseTemplateFiles(files, parse); err != nil {
+		return nil, err
+	}
+	sources := templateSources(files)
+	if o.TemplateFile != "" {
+		b, err := os.ReadFile(o.TemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("template_file: %w", err)
+		}
+		if err := parse(filepath.Base(o.TemplateFile), string(b), true); err != nil {
+			return nil, fmt.Errorf("%s: %w (parsed with %s)", o.TemplateFile, err, sourceList(files))
+		}
+		sources[filepath.Base(o.TemplateFile)] = o.TemplateFile
+	}
+	return sourcedTemplate{templateExecutor: t, o: o, sources: sources}, nil
+}
+
+// renderTemplate executes the "output" template, or the "combined" template
+// when all files are rendered into one document.
+func (o *GenOpts) renderTemplate(data *TemplateData, w io.Writer) error {
+	t, err := o.parseTemplate()
 	if err != nil {
 		return err
 	}
-	return t.ExecuteTemplate(g, "output", file)
+	name := "output"
+	switch {
+	case o.Split == splitPage:
+		name = "page"
+		if data.File == nil {
+			name = "index"
+		}
+		if !strings.Contains(t.DefinedTemplates(), `"`+name+`"`) {
+			return fmt.Errorf("format %q does not support split=%s", o.Format, splitPage)
+		}
+	case data.File == nil:
+		name = "combined"
+		if !strings.Contains(t.DefinedTemplates(), `"combined"`) {
+			return fmt.Errorf("format %q does not support combined output", o.Format)
+		}
+	}
+	return t.ExecuteTemplate(w, name, data)
 }
 
 // Template Helpers
@@ -227,10 +1722,116 @@ var (
 	spacePattern        = regexp.MustCompile("( )+")
 	multiNewlinePattern = regexp.MustCompile(`(\r\n|\r|\n){2,}`)
 	specialCharsPattern = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+	commentPattern      = regexp.MustCompile("\n// ?")
+	fencePattern        = regexp.MustCompile("^[ \t]*(```+|~~~+)[ \t]*([^`\\s]*)")
+
+	adocEscaper       = strings.NewReplacer("|", `\|`, "{", `\{`)
+	confluenceEscaper = strings.NewReplacer("|", `\|`, "{", `\{`, "}", `\}`)
+	dokuwikiEscaper   = strings.NewReplacer("|", "%%|%%", "^", "%%^%%")
+	dokuwikiIDPattern = regexp.MustCompile(`[^a-z0-9.-]+`)
+	textileEscaper    = strings.NewReplacer("|", "&#124;")
+	mdxEscaper        = strings.NewReplacer("<", "&lt;", "{", "&#123;", "}", "&#125;")
+	mdEscaper         = strings.NewReplacer(
+		`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "|", `\|`, "[", `\[`, "]", `\]`, "<", `\<`,
+	)
+	texEscaper = strings.NewReplacer(
+		`\`, `\textbackslash{}`, "_", `\_`, "%", `\%`, "&", `\&`, "#", `\#`, "$", `\$`,
+		"{", `\{`, "}", `\}`, "~", `\textasciitilde{}`, "^", `\textasciicircum{}`,
+		"<", `\textless{}`, ">", `\textgreater{}`,
+	)
+	xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
 )
 
+// commentBlock is a run of prose or a fenced code block of a comment.
+type commentBlock struct {
+	Text string
+	// Code is set for fenced code blocks, whose Text holds the lines
+	// between the fences with the indentation of the opening fence
+	// removed. Fence is the opening fence, e.g. "```", and Lang its info
+	// string, e.g. "json".
+	Code  bool
+	Fence string
+	Lang  string
+}
+
+// splitFences splits content into prose and the ``` or ~~~ fenced code
+// blocks in it. A block without a closing fence runs to the end of content.
+func splitFences(content string) []commentBlock {
+	var (
+		blocks []commentBlock
+		prose  []string
+	)
+	lines := strings.Split(strings.Replace(content, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		m := fencePattern.FindStringSubmatch(lines[i])
+		if m == nil {
+			prose = append(prose, lines[i])
+			continue
+		}
+		if len(prose) > 0 {
+			blocks = append(blocks, commentBlock{Text: strings.Join(prose, "\n")})
+			prose = nil
+		}
+		indent := len(lines[i]) - len(strings.TrimLeft(lines[i], " \t"))
+		var code []string
+		for i++; i < len(lines); i++ {
+			if l := strings.TrimSpace(lines[i]); strings.HasPrefix(l, m[1]) && strings.Trim(l, m[1][:1]) == "" {
+				break
+			}
+			code = append(code, trimIndent(lines[i], indent))
+		}
+		blocks = append(blocks, commentBlock{Text: strings.Join(code, "\n"), Code: true, Fence: m[1], Lang: m[2]})
+	}
+	if len(prose) > 0 {
+		blocks = append(blocks, commentBlock{Text: strings.Join(prose, "\n")})
+	}
+	return blocks
+}
+
+// trimIndent removes up to n leading spaces or tabs from line.
+func trimIndent(line string, n int) string {
+	for i := 0; i < n && line != "" && (line[0] == ' ' || line[0] == '\t'); i++ {
+		line = line[1:]
+	}
+	return line
+}
+
+// hasFences reports whether blocks hold a fenced code block.
+func hasFences(blocks []commentBlock) bool {
+	for _, b := range blocks {
+		if b.Code {
+			return true
+		}
+	}
+	return false
+}
+
+// pFilter wraps the lines of content in HTML paragraphs. Fenced code blocks
+// are kept as preformatted text instead.
 func pFilter(content string) htmltemplate.HTML {
+	blocks := splitFences(content)
+	if !hasFences(blocks) {
+		return pParagraphs(content)
+	}
+	var b strings.Builder
+	for _, block := range blocks {
+		switch {
+		case block.Code && block.Lang != "":
+			fmt.Fprintf(&b, `<pre><code class="language-%s">%s</code></pre>`, htmltemplate.HTMLEscapeString(block.Lang), htmltemplate.HTMLEscapeString(block.Text))
+		case block.Code:
+			fmt.Fprintf(&b, "<pre><code>%s</code></pre>", htmltemplate.HTMLEscapeString(block.Text))
+		case strings.TrimSpace(block.Text) != "":
+			b.WriteString(string(pParagraphs(strings.TrimSpace(block.Text))))
+		}
+	}
+	return htmltemplate.HTML(b.String())
+}
+
+func pParagraphs(content string) htmltemplate.HTML {
 	paragraphs := paraPattern.Split(content, -1)
+	for i, p := range paragraphs {
+		paragraphs[i] = htmltemplate.HTMLEscapeString(p)
+	}
 	return htmltemplate.HTML(fmt.Sprintf("<p>%s</p>", strings.Join(paragraphs, "</p><p>")))
 }
 
@@ -239,7 +1840,33 @@ func paraFilter(content string) string {
 	return fmt.Sprintf("<para>%s</para>", strings.Join(paragraphs, "</para><para>"))
 }
 
+// inlineFilter collapses the whitespace of content, paragraph breaks
+// included, into single spaces and trims it, for descriptions that must stay
+// on one line such as the cells of markdown tables.
+func inlineFilter(content string) string {
+	return strings.Join(strings.Fields(content), " ")
+}
+
+// nobrFilter joins the lines of each paragraph of content. Fenced code
+// blocks are kept as they are, as paragraphs of their own.
 func nobrFilter(content string) string {
+	blocks := splitFences(content)
+	if !hasFences(blocks) {
+		return nobrParagraphs(content)
+	}
+	var parts []string
+	for _, block := range blocks {
+		switch {
+		case block.Code:
+			parts = append(parts, block.Fence+block.Lang+"\n"+block.Text+"\n"+block.Fence)
+		case strings.TrimSpace(block.Text) != "":
+			parts = append(parts, nobrParagraphs(strings.TrimSpace(block.Text)))
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func nobrParagraphs(content string) string {
 	normalized := strings.Replace(content, "\r\n", "\n", -1)
 	paragraphs := multiNewlinePattern.Split(normalized, -1)
 	for i, p := range paragraphs {
@@ -249,3 +1876,181 @@ func nobrFilter(content string) string {
 	}
 	return strings.Join(paragraphs, "\n\n")
 }
+
+// adocEscapeFilter escapes characters that would otherwise end an AsciiDoc
+// table cell or start an attribute reference.
+func adocEscapeFilter(content string) string {
+	return adocEscaper.Replace(content)
+}
+
+// paragraphs splits content into trimmed, single-line paragraphs.
+func paragraphs(content string) []string {
+	var paragraphs []string
+	for _, p := range strings.Split(nobrFilter(content), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+// adocParaFilter renders content as AsciiDoc paragraphs separated by blank
+// lines. Lines are trimmed since indented lines start a literal block.
+func adocParaFilter(content string) string {
+	return strings.Join(paragraphs(content), "\n\n")
+}
+
+// confluenceEscapeFilter escapes characters that would otherwise end a
+// Confluence table cell or start a macro.
+func confluenceEscapeFilter(content string) string {
+	return confluenceEscaper.Replace(content)
+}
+
+// confluenceParaFilter renders content as escaped Confluence paragraphs
+// separated by blank lines.
+func confluenceParaFilter(content string) string {
+	return confluenceEscapeFilter(strings.Join(paragraphs(content), "\n\n"))
+}
+
+// storageParaFilter renders content as XML-escaped paragraphs of the
+// Confluence storage format. Fenced code blocks become code macros, see
+// storageCodeFilter.
+func storageParaFilter(content string) string {
+	var b strings.Builder
+	for _, block := range splitFences(content) {
+		if block.Code {
+			b.WriteString(storageCodeFilter(block.Lang, block.Text))
+			continue
+		}
+		for _, p := range paragraphs(block.Text) {
+			fmt.Fprintf(&b, "<p>%s</p>", xmlEscaper.Replace(p))
+		}
+	}
+	return b.String()
+}
+
+// storageCodeFilter renders code as a Confluence code macro highlighting
+// lang, which may be empty. The code is kept verbatim in a CDATA section.
+func storageCodeFilter(lang, code string) string {
+	var b strings.Builder
+	b.WriteString(`<ac:structured-macro ac:name="code">`)
+	if lang != "" {
+		fmt.Fprintf(&b, `<ac:parameter ac:name="language">%s</ac:parameter>`, xmlEscaper.Replace(lang))
+	}
+	fmt.Fprintf(&b, "<ac:plain-text-body><![CDATA[%s]]></ac:plain-text-body></ac:structured-macro>", strings.ReplaceAll(code, "]]>", "]]]]><![CDATA[>"))
+	return b.String()
+}
+
+// dokuwikiEscapeFilter escapes the characters that separate DokuWiki table
+// cells by wrapping them in %% nowiki markers.
+func dokuwikiEscapeFilter(content string) string {
+	return dokuwikiEscaper.Replace(content)
+}
+
+// dokuwikiParaFilter renders content as DokuWiki paragraphs separated by
+// blank lines. Lines are trimmed since indented lines are preformatted.
+func dokuwikiParaFilter(content string) string {
+	return strings.Join(paragraphs(content), "\n\n")
+}
+
+// dokuwikiID returns the id DokuWiki gives a section headed by title, so
+// internal links can point at it. Like DokuWiki's sectionID, it lowercases
+// the title, turns runs of other characters into an underscore and drops
+// dots.
+func dokuwikiID(title string) string {
+	id := dokuwikiIDPattern.ReplaceAllString(strings.ToLower(title), "_")
+	id = strings.ReplaceAll(strings.Trim(id, "._-"), ".", "")
+	return strings.TrimLeft(id, "0123456789_-")
+}
+
+// manEscapeFilter escapes content for troff. Backslashes are written as \e
+// and lines starting with a dot or an apostrophe are prefixed with the \&
+// zero-width character, so comment text can't inject troff requests.
+func manEscapeFilter(content string) string {
+	lines := strings.Split(strings.ReplaceAll(content, `\`, `\e`), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// manParaFilter renders content as escaped troff paragraphs separated by
+// the macro request, e.g. ".PP" in a section body or ".IP" to keep the
+// indentation of a tagged paragraph.
+func manParaFilter(macro, content string) string {
+	paras := paragraphs(content)
+	for i, p := range paras {
+		paras[i] = manEscapeFilter(p)
+	}
+	return strings.Join(paras, "\n"+macro+"\n")
+}
+
+// sidebarPosition returns the sidebar_position of the document generated for
+// the file at index, or zero when positions are not wanted.
+func (o *GenOpts) sidebarPosition(index int) int {
+	if o.SidebarPositionStart == 0 {
+		return 0
+	}
+	return o.SidebarPositionStart + index
+}
+
+// mdEscapeFilter escapes the characters that start Markdown emphasis, code
+// spans, links or HTML, and pipes, which would otherwise end a table cell.
+func mdEscapeFilter(content string) string {
+	return mdEscaper.Replace(content)
+}
+
+// mdxEscapeFilter escapes characters that MDX would otherwise parse as JSX
+// or as a JavaScript expression.
+func mdxEscapeFilter(content string) string {
+	return mdxEscaper.Replace(content)
+}
+
+// plantumlID returns the alias a PlantUML diagram declares the message or
+// enum named name as. Dots would make PlantUML nest it in packages.
+func plantumlID(name interface{}) string {
+	return strings.ReplaceAll(fmt.Sprint(name), ".", "_")
+}
+
+// rstParaFilter renders content as reStructuredText paragraphs. Every
+// paragraph after the first is indented by indent spaces so the text can
+// continue a directive or list-table cell.
+func rstParaFilter(indent int, content string) string {
+	return strings.Join(paragraphs(content), "\n\n"+strings.Repeat(" ", indent))
+}
+
+// rstTitle underlines title with char, matching the title's width.
+func rstTitle(title interface{}, char string) string {
+	s := fmt.Sprint(title)
+	return s + "\n" + strings.Repeat(char, utf8.RuneCountInString(s))
+}
+
+// texEscapeFilter escapes the LaTeX special characters in s.
+func texEscapeFilter(s interface{}) string {
+	return texEscaper.Replace(fmt.Sprint(s))
+}
+
+// texParaFilter renders content as escaped LaTeX paragraphs separated by
+// blank lines.
+func texParaFilter(content string) string {
+	return texEscapeFilter(strings.Join(paragraphs(content), "\n\n"))
+}
+
+// textileEscapeFilter escapes pipes, which would otherwise end a Textile
+// table cell, as HTML entities.
+func textileEscapeFilter(content string) string {
+	return textileEscaper.Replace(content)
+}
+
+// textileParaFilter renders content as Textile paragraphs separated by blank
+// lines.
+func textileParaFilter(content string) string {
+	return strings.Join(paragraphs(content), "\n\n")
+}
+
+// xmlEscapeFilter escapes the XML special characters in s.
+func xmlEscapeFilter(s interface{}) string {
+	return xmlEscaper.Replace(fmt.Sprint(s))
+}
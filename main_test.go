@@ -1,6 +1,2966 @@
 package main
 
-import "testing"
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// examplePlugin returns a plugin for the protos in testdata/example1 as if
+// protoc had invoked it with the given parameter. The descriptors are read
+// from testdata/example1/fileset.pb, see the Makefile.
+func examplePlugin(t *testing.T, param string) *protogen.Plugin {
+	t.Helper()
+	b, err := os.ReadFile("testdata/example1/fileset.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fds descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &fds); err != nil {
+		t.Fatal(err)
+	}
+	req := &pluginpb.CodeGeneratorRequest{
+		Parameter: proto.String(param),
+		ProtoFile: fds.File,
+	}
+	for _, f := range fds.File {
+		if strings.HasPrefix(f.GetName(), "example1/") {
+			req.FileToGenerate = append(req.FileToGenerate, f.GetName())
+		}
+	}
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gen
+}
+
+// generateExamples runs the generator over the example protos and returns
+// the generated files by name.
+func generateExamples(t *testing.T, opts GenOpts) map[string]string {
+	t.Helper()
+	gen := examplePlugin(t, "paths=source_relative")
+	if err := opts.generate(gen); err != nil {
+		t.Fatal(err)
+	}
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatal(resp.GetError())
+	}
+	files := make(map[string]string)
+	for _, f := range resp.File {
+		files[f.GetName()] = f.GetContent()
+	}
+	return files
+}
+
+// checkGolden compares got against the file testdata/name.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s does not match the generated output; run go test -update to regenerate it.\ngot:\n%s", path, got)
+	}
+}
+
+// exampleDescriptor returns the descriptor with the given full name from
+// the example protos.
+func exampleDescriptor(t *testing.T, name protoreflect.FullName) protoreflect.Descriptor {
+	t.Helper()
+	for _, f := range examplePlugin(t, "").Files {
+		if d := findDescriptor(f.Desc, name); d != nil {
+			return d
+		}
+	}
+	t.Fatalf("descriptor %v not found", name)
+	return nil
+}
+
+// exampleMessage returns the message with the given full name from the
+// example protos.
+func exampleMessage(t *testing.T, name protoreflect.FullName) *protogen.Message {
+	t.Helper()
+	for _, f := range examplePlugin(t, "").Files {
+		for _, m := range f.Messages {
+			if m.Desc.FullName() == name {
+				return m
+			}
+		}
+	}
+	t.Fatalf("message %v not found", name)
+	return nil
+}
+
+func findDescriptor(f protoreflect.FileDescriptor, name protoreflect.FullName) protoreflect.Descriptor {
+	var find func(msgs protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors) protoreflect.Descriptor
+	find = func(msgs protoreflect.MessageDescriptors, enums protoreflect.EnumDescriptors) protoreflect.Descriptor {
+		for i := 0; i < enums.Len(); i++ {
+			if enums.Get(i).FullName() == name {
+				return enums.Get(i)
+			}
+		}
+		for i := 0; i < msgs.Len(); i++ {
+			m := msgs.Get(i)
+			if m.FullName() == name {
+				return m
+			}
+			if d := find(m.Messages(), m.Enums()); d != nil {
+				return d
+			}
+		}
+		return nil
+	}
+	return find(f.Messages(), f.Enums())
+}
 
 func TestExamples(t *testing.T) {
 }
+
+func TestLongName(t *testing.T) {
+	tests := []struct {
+		name protoreflect.FullName
+		want string
+	}{
+		{"com.example.Vehicle", "Vehicle"},
+		{"com.example.Coolness", "Coolness"},
+		{"com.example.Vehicle.Category", "Vehicle.Category"},
+		{"com.example.Manufacturer.Category", "Manufacturer.Category"},
+		{"com.example.nested.Outer.Middle", "Outer.Middle"},
+		{"com.example.nested.Outer.Middle.Inner", "Outer.Middle.Inner"},
+		{"com.example.nested.Outer.Middle.Inner.Depth", "Outer.Middle.Inner.Depth"},
+	}
+	for _, tt := range tests {
+		if got := longName(exampleDescriptor(t, tt.name)); got != tt.want {
+			t.Errorf("longName(%v) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMapType(t *testing.T) {
+	msg := exampleMessage(t, "com.example.maps.Resource")
+	want := map[string]struct{ typ, key, value, link string }{
+		"annotations": {"map<string, string>", "string", "string", ""},
+		"labels":      {"map<string, Label>", "string", "Label", "#com-example-maps-Label"},
+		"statuses":    {"map<int64, Status>", "int64", "Status", "#com-example-maps-Status"},
+		"revisions":   {"map<int64, string>", "int64", "string", ""},
+	}
+	o := &GenOpts{}
+	typeLink := o.templateFuncMap()["type_link"].(func(*protogen.Field) string)
+	for _, f := range msg.Fields {
+		w := want[string(f.Desc.Name())]
+		if !f.Desc.IsMap() {
+			t.Errorf("%v is not a map", f.Desc.Name())
+		}
+		if got := mapType(f); got != w.typ {
+			t.Errorf("mapType(%v) = %q, want %q", f.Desc.Name(), got, w.typ)
+		}
+		// field_type names map fields by their declared type rather than
+		// their entry message, e.g. Resource.LabelsEntry.
+		if got := o.fieldType(f); got != w.typ {
+			t.Errorf("fieldType(%v) = %q, want %q", f.Desc.Name(), got, w.typ)
+		}
+		if got := o.mapKeyType(f); got != w.key {
+			t.Errorf("mapKeyType(%v) = %q, want %q", f.Desc.Name(), got, w.key)
+		}
+		if got := o.mapValueType(f); got != w.value {
+			t.Errorf("mapValueType(%v) = %q, want %q", f.Desc.Name(), got, w.value)
+		}
+		if got := typeLink(f); got != w.link {
+			t.Errorf("type_link(%v) = %q, want %q", f.Desc.Name(), got, w.link)
+		}
+	}
+
+	f := exampleMessage(t, "com.example.maps.Label").Fields[0]
+	if key, value := o.mapKeyType(f), o.mapValueType(f); key != "" || value != "" {
+		t.Errorf("mapKeyType and mapValueType of %v = %q and %q, want empty", f.Desc.Name(), key, value)
+	}
+
+	// The entry messages of map fields are left out of documents.
+	content := generateExamples(t, GenOpts{Format: "markdown"})["example1/maps.md"]
+	if strings.Contains(content, "Entry") {
+		t.Errorf("maps.md documents map entry messages:\n%s", content)
+	}
+}
+
+func TestFieldAnchor(t *testing.T) {
+	tests := []struct {
+		msg   protoreflect.FullName
+		field protoreflect.Name
+		want  string
+	}{
+		{"com.example.booking.Booking", "status", "com-example-booking-BookingStatus"},
+		{"com.example.validation.SignupRequest", "plan", "com-example-validation-Plan"},
+		{"com.example.maps.Resource", "labels", "com-example-maps-Label"},
+		{"com.example.maps.Resource", "statuses", "com-example-maps-Status"},
+		{"com.example.maps.Resource", "annotations", ""},
+		{"com.example.booking.Booking", "vehicle_id", ""},
+	}
+	o := &GenOpts{}
+	for _, tt := range tests {
+		var field *protogen.Field
+		for _, f := range exampleMessage(t, tt.msg).Fields {
+			if f.Desc.Name() == tt.field {
+				field = f
+			}
+		}
+		if got := o.fieldAnchor(field); got != tt.want {
+			t.Errorf("fieldAnchor(%s.%s) = %q, want %q", tt.msg, tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestScalarTypes(t *testing.T) {
+	types := make(map[string]bool)
+	for _, st := range scalarTypes {
+		types[st.ProtoType] = true
+		if st.CppType == "" || st.JavaType == "" || st.PythonType == "" || st.GoType == "" {
+			t.Errorf("%s lacks a language mapping: %+v", st.ProtoType, st)
+		}
+	}
+	for k := protoreflect.Kind(1); k <= protoreflect.Sint64Kind; k++ {
+		if k == protoreflect.EnumKind || k == protoreflect.MessageKind || k == protoreflect.GroupKind {
+			continue
+		}
+		if !types[k.String()] {
+			t.Errorf("scalarTypes lacks %s", k)
+		}
+	}
+
+	tests := []struct {
+		format, name, link, target string
+	}{
+		{"markdown", "example1/booking.md", "|[int32](#int32)|", `| <a name="int32"></a> int32 |`},
+		{"html", "example1/booking.html", `<a href="#int32">int32</a>`, `<tr id="int32"><td>int32</td>`},
+	}
+	for _, tt := range tests {
+		got := generateExamples(t, GenOpts{Format: tt.format})[tt.name]
+		if !strings.Contains(got, tt.link) || strings.Count(got, tt.target) != 1 {
+			t.Errorf("%s does not link %q to %q:\n%s", tt.name, tt.link, tt.target, got)
+		}
+		got = generateExamples(t, GenOpts{Format: tt.format, Content: contentServices})[tt.name]
+		if strings.Contains(got, "Scalar Value Types") {
+			t.Errorf("%s with content=services has a scalar value types appendix:\n%s", tt.name, got)
+		}
+	}
+	for name, content := range generateExamples(t, GenOpts{Format: "html", Split: splitPage}) {
+		if strings.Contains(content, `href="#int32"`) {
+			t.Errorf("%s links to the scalar value types appendix, which pages don't have", name)
+		}
+	}
+}
+
+func TestScalarNames(t *testing.T) {
+	for _, st := range scalarTypes {
+		if genericScalarNames[st.ProtoType] == "" {
+			t.Errorf("genericScalarNames lacks %s", st.ProtoType)
+		}
+	}
+	tests := []struct {
+		opts GenOpts
+		name string
+		want string
+	}{
+		{GenOpts{}, "int64", "int64"},
+		{GenOpts{ScalarNames: scalarNamesProto}, "bytes", "bytes"},
+		{GenOpts{ScalarNames: scalarNamesGeneric}, "int64", "64-bit integer"},
+		{GenOpts{ScalarNames: scalarNamesGeneric}, "bytes", "byte string"},
+		{GenOpts{Scalars: []string{"bytes=blob"}}, "bytes", "blob"},
+		{GenOpts{ScalarNames: scalarNamesGeneric, Scalars: []string{"bytes=blob"}}, "bytes", "blob"},
+		{GenOpts{ScalarNames: scalarNamesGeneric}, "com.example.Vehicle", "com.example.Vehicle"},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.scalarType(tt.name); got != tt.want {
+			t.Errorf("scalarType(%q) with %+v = %q, want %q", tt.name, tt.opts, got, tt.want)
+		}
+	}
+	var f scalarFlag
+	for _, s := range []string{"bytes", "bytes=", "Vehicle=car"} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("scalar %q was accepted", s)
+		}
+	}
+
+	files := generateExamples(t, GenOpts{Format: "markdown", ScalarNames: scalarNamesGeneric, Scalars: []string{"string=text"}})
+	for name, want := range map[string][]string{
+		// Fields keep linking to the appendix, which names the proto types.
+		"example1/booking.md": {"|[32-bit integer](#int32)|", "|[text](#string)|", `| <a name="int32"></a> int32 |`},
+		"example1/maps.md":    {"`map<text, text>`", "`map<64-bit integer, Status>`"},
+	} {
+		for _, w := range want {
+			if !strings.Contains(files[name], w) {
+				t.Errorf("%s does not contain %q:\n%s", name, w, files[name])
+			}
+		}
+	}
+	files = generateExamples(t, GenOpts{Format: "json", ScalarNames: scalarNamesGeneric})
+	if !strings.Contains(files["example1/booking.json"], `"type": "int32"`) {
+		t.Errorf("json output does not keep the proto type names:\n%s", files["example1/booking.json"])
+	}
+	o := &GenOpts{ScalarNames: "java"}
+	if err := o.generate(examplePlugin(t, "")); err == nil {
+		t.Error("generate with an invalid scalar_names succeeded")
+	}
+}
+
+func TestGroups(t *testing.T) {
+	msg := exampleMessage(t, "com.example.groups.SearchResponse")
+	result, total := msg.Fields[0], msg.Fields[1]
+	if !isGroup(result) || !isGroup(result.Message) || isGroup(total) || isGroup(msg) {
+		t.Errorf("isGroup misreports the fields of %s", msg.Desc.FullName())
+	}
+	if got := fieldPath(result.Message.Fields[0]); got != "result.url" {
+		t.Errorf("fieldPath = %q, want %q", got, "result.url")
+	}
+
+	for _, tt := range []struct {
+		opts GenOpts
+		name string
+		want []string
+	}{
+		{GenOpts{Format: "markdown"}, "example1/groups.md", []string{
+			"| result | 1 | result | repeated |group|  | The results of the page. |\n" +
+				"| result.url | 2 | url | required |[string](#string)|  | Address of the result. |\n" +
+				"| result.title | 3 | title | optional |[string](#string)|  | Title of the result. |\n",
+		}},
+		{GenOpts{Format: "markdown", FieldLayout: fieldLayoutList}, "example1/groups.md", []string{"**result.url**<br>"}},
+		{GenOpts{Format: "html"}, "example1/groups.html", []string{
+			"<tr><td>result</td><td>1</td><td>result</td><td>repeated</td><td>group</td><td>The results of the page.  </td></tr>\n<tr><td>result.url</td><td>2</td><td>url</td><td>required</td>",
+		}},
+		// Other formats document groups as nested messages, and the group
+		// field with the comment of the group.
+		{GenOpts{Format: "asciidoc"}, "example1/groups.adoc", []string{"SearchResponse.Result", "The results of the page."}},
+	} {
+		content := generateExamples(t, tt.opts)[tt.name]
+		for _, want := range tt.want {
+			if !strings.Contains(content, want) {
+				t.Errorf("%s with %+v does not contain %q:\n%s", tt.name, tt.opts, want, content)
+			}
+		}
+	}
+	for name, content := range map[string]string{
+		"example1/groups.md":   generateExamples(t, GenOpts{Format: "markdown"})["example1/groups.md"],
+		"example1/groups.html": generateExamples(t, GenOpts{Format: "html"})["example1/groups.html"],
+	} {
+		if strings.Contains(content, "com-example-groups-SearchResponse-Result") {
+			t.Errorf("%s documents the group in a section of its own:\n%s", name, content)
+		}
+	}
+}
+
+func TestProtoSnippet(t *testing.T) {
+	tmpl := filepath.Join(t.TempDir(), "snippets.proto.tmpl")
+	if err := os.WriteFile(tmpl, []byte(`{{define "output"}}
+{{- range .Services }}{{ proto_snippet . }}{{ end }}
+{{- range .Messages }}{{ proto_snippet . }}{{ end }}
+{{- range .Enums }}{{ proto_snippet . }}{{ end }}
+{{- end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files := generateExamples(t, GenOpts{TemplateFile: tmpl})
+	var got strings.Builder
+	for _, name := range []string{"booking", "deprecated", "exclude", "field_presence", "groups", "maps", "nested", "presence", "reserved", "vehicle"} {
+		fmt.Fprintf(&got, "// example1/%s.proto\n%s\n", name, files["example1/"+name+".proto"])
+	}
+	checkGolden(t, "example1/snippets.golden", got.String())
+
+	// Every field and enum value not excluded is declared with its number.
+	for _, f := range examplePlugin(t, "").Files {
+		if !f.Generate {
+			continue
+		}
+		for _, m := range f.Messages {
+			snippet, err := protoSnippet(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range m.Fields {
+				if want := fmt.Sprintf(" %s = %d", field.Desc.Name(), field.Desc.Number()); !isGroup(field) && !strings.Contains(snippet, want) {
+					t.Errorf("snippet of %s does not declare %q:\n%s", m.Desc.FullName(), want, snippet)
+				}
+			}
+		}
+		for _, e := range f.Enums {
+			snippet, err := protoSnippet(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range enumValues(e) {
+				if want := fmt.Sprintf(" %s = %d", v.Desc.Name(), v.Desc.Number()); !strings.Contains(snippet, want) {
+					t.Errorf("snippet of %s does not declare %q:\n%s", e.Desc.FullName(), want, snippet)
+				}
+			}
+		}
+	}
+	if _, err := protoSnippet(exampleMessage(t, "com.example.maps.Resource").Fields[0]); err == nil {
+		t.Error("proto_snippet of a field succeeded")
+	}
+}
+
+func TestFieldLabel(t *testing.T) {
+	tests := []struct {
+		msg      protoreflect.FullName
+		field    protoreflect.Name
+		want     string
+		presence bool
+	}{
+		{"com.example.Manufacturer", "id", "required", true},
+		{"com.example.Manufacturer", "details", "optional", true},
+		{"com.example.proto3.MyMessage", "not_tracked", "", false},
+		{"com.example.proto3.MyMessage", "tracked", "optional", true},
+		{"com.example.proto3.AnotherMessage", "my_string", "", true},
+		{"com.example.maps.Label", "aliases", "repeated", false},
+		{"com.example.maps.Resource", "labels", "map", false},
+	}
+	for _, tt := range tests {
+		for _, f := range exampleMessage(t, tt.msg).Fields {
+			if f.Desc.Name() != tt.field {
+				continue
+			}
+			if got := fieldLabel(f); got != tt.want {
+				t.Errorf("fieldLabel(%v.%v) = %q, want %q", tt.msg, tt.field, got, tt.want)
+			}
+			if got := hasPresence(f); got != tt.presence {
+				t.Errorf("hasPresence(%v.%v) = %v, want %v", tt.msg, tt.field, got, tt.presence)
+			}
+		}
+	}
+
+	// Field tables have a Label column.
+	for _, tt := range []struct {
+		format, name, want string
+	}{
+		{"asciidoc", "example1/maps.adoc", "| labels | labels | map | "},
+		{"rst", "example1/maps.rst", "   * - ``aliases``\n     - ``aliases``\n     - repeated\n"},
+		{"dokuwiki", "example1/field_presence.txt", "| tracked | tracked | optional | "},
+		{"html", "example1/maps.html", "<td>labels</td><td>map</td>"},
+	} {
+		content := generateExamples(t, GenOpts{Format: tt.format})[tt.name]
+		if !strings.Contains(content, tt.want) {
+			t.Errorf("%s does not contain %q:\n%s", tt.name, tt.want, content)
+		}
+	}
+}
+
+func TestDefaultValue(t *testing.T) {
+	tests := []struct {
+		msg   protoreflect.FullName
+		field protoreflect.Name
+		want  string
+	}{
+		{"com.example.Manufacturer", "category", "CATEGORY_EXTERNAL"},
+		{"com.example.Manufacturer", "code", ""},
+		{"com.example.Vehicle", "daily_hire_rate_dollars", "50"},
+		{"com.example.proto3.MyMessage", "tracked", ""},
+	}
+	for _, tt := range tests {
+		for _, f := range exampleMessage(t, tt.msg).Fields {
+			if f.Desc.Name() != tt.field {
+				continue
+			}
+			if got := defaultValue(f); got != tt.want {
+				t.Errorf("defaultValue(%v.%v) = %q, want %q", tt.msg, tt.field, got, tt.want)
+			}
+		}
+	}
+	for _, f := range examplePlugin(t, "").Files {
+		for _, x := range f.Extensions {
+			if x.Desc.FullName() == "com.example.country" {
+				if got, want := defaultValue(x), `"China"`; got != want {
+					t.Errorf("defaultValue(%v) = %q, want %q", x.Desc.FullName(), got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestJSONNameColumn(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown"})
+	for name, want := range map[string]string{
+		"example1/vehicle.md": "| model_code | 2 | modelCode | required |",
+		"example1/rest.md":    "| pages | 2 | pageCount |  |",
+	} {
+		if !strings.Contains(files[name], want) {
+			t.Errorf("%s does not contain %q", name, want)
+		}
+	}
+}
+
+func TestEnumValues(t *testing.T) {
+	var status *protogen.Enum
+	for _, f := range examplePlugin(t, "").Files {
+		for _, e := range f.Enums {
+			if e.Desc.FullName() == "com.example.maps.Status" {
+				status = e
+			}
+		}
+	}
+	if status == nil {
+		t.Fatal("enum com.example.maps.Status not found")
+	}
+	var got []string
+	for _, v := range enumValues(status) {
+		got = append(got, string(v.Desc.Name()))
+	}
+	want := []string{"STATUS_UNSPECIFIED", "STATUS_ACTIVE"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("enumValues() = %v, want %v", got, want)
+	}
+}
+
+func TestOneofs(t *testing.T) {
+	msg := exampleMessage(t, "com.example.proto3.MyMessage")
+	if got := oneofs(msg); len(got) != 0 {
+		t.Errorf("oneofs(%v) = %v, want none for a proto3 optional field", msg.Desc.Name(), got)
+	}
+	for _, f := range msg.Fields {
+		if inRealOneof(f) {
+			t.Errorf("inRealOneof(%v) = true, want false", f.Desc.Name())
+		}
+		if name := oneofName(f); name != "" {
+			t.Errorf("oneofName(%v) = %q, want none", f.Desc.Name(), name)
+		}
+	}
+
+	msg = exampleMessage(t, "com.example.proto3.AnotherMessage")
+	got := oneofs(msg)
+	if len(got) != 1 || got[0].Desc.Name() != "payload" {
+		t.Fatalf("oneofs(%v) = %v, want [payload]", msg.Desc.Name(), got)
+	}
+	var names []string
+	for _, f := range oneofFields(got[0]) {
+		names = append(names, string(f.Desc.Name()))
+		if !inRealOneof(f) {
+			t.Errorf("inRealOneof(%v) = false, want true", f.Desc.Name())
+		}
+		if name := oneofName(f); name != "payload" {
+			t.Errorf("oneofName(%v) = %q, want payload", f.Desc.Name(), name)
+		}
+	}
+	if want := []string{"my_message", "my_string"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("oneofFields(payload) = %v, want %v", names, want)
+	}
+}
+
+func TestOneofGrouping(t *testing.T) {
+	content := generateExamples(t, GenOpts{Format: "markdown"})["example1/presence.md"]
+	// Proto3 optional fields stay in declaration order instead of being
+	// grouped like a oneof.
+	tracked, label := strings.Index(content, "| tracked |"), strings.Index(content, "| label |")
+	if tracked < 0 || label < 0 || tracked > label {
+		t.Errorf("presence.md does not list tracked before label:\n%s", content)
+	}
+	if strings.Contains(content, "One of `_tracked`") {
+		t.Errorf("presence.md groups the synthetic oneof of tracked:\n%s", content)
+	}
+	// The members of payload follow the other fields under a sub-heading
+	// with the comment of the oneof and a table of their own.
+	want := "| id | 1 | id |  |[int32](#int32)|  |  |\n\n" +
+		"#### One of `payload`\n\nThe payload of the message, either structured or as text.\n\n" +
+		"Only one of the following fields can be set.\n\n" +
+		"| Field | Number | JSON Name | Label | Type | Default | Description |\n" +
+		"| ----- | ------ | --------- | ----- | ---- | ------- | ----------- |\n" +
+		"| my_message | 2 | myMessage |"
+	if !strings.Contains(content, want) {
+		t.Errorf("presence.md does not group the payload oneof:\n%s", content)
+	}
+
+	content = generateExamples(t, GenOpts{Format: "markdown", FieldLayout: fieldLayoutList})["example1/presence.md"]
+	if want := "#### One of `payload`\n\nThe payload of the message, either structured or as text.\n\nOnly one of the following fields can be set.\n\n**my_message**<br>"; !strings.Contains(content, want) {
+		t.Errorf("presence.md with field_layout=list does not group the payload oneof:\n%s", content)
+	}
+
+	content = generateExamples(t, GenOpts{Format: "html"})["example1/presence.html"]
+	if want := "</table>\n<h3>One of <code>payload</code></h3>\n<p>The payload of the message, either structured or as text. </p>\n<p>Only one of the following fields can be set.</p>\n<table>"; !strings.Contains(content, want) {
+		t.Errorf("presence.html does not group the payload oneof:\n%s", content)
+	}
+	if strings.Contains(content, "<code>_tracked</code>") {
+		t.Errorf("presence.html groups the synthetic oneof of tracked:\n%s", content)
+	}
+}
+
+func TestReserved(t *testing.T) {
+	status := exampleMessage(t, "com.example.reserved.BookingStatus")
+	if got, want := reservedRanges(status), []string{"3", "10 to 12", "1000 to max"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reservedRanges = %q, want %q", got, want)
+	}
+	if got, want := reservedNames(status), []string{"code", "label"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reservedNames = %q, want %q", got, want)
+	}
+	if got := reservedRanges(exampleMessage(t, "com.example.booking.Booking")); got != nil {
+		t.Errorf("reservedRanges of Booking = %q, want none", got)
+	}
+
+	tests := []struct {
+		format, name, want string
+	}{
+		{"markdown", "example1/reserved.md", "\n**Reserved:** 3, 10 to 12, 1000 to max, `code`, `label`\n"},
+		{"html", "example1/reserved.html", `<p class="reserved">Reserved: 3, 10 to 12, 1000 to max, <code>code</code>, <code>label</code></p>`},
+	}
+	for _, tt := range tests {
+		got := generateExamples(t, GenOpts{Format: tt.format})[tt.name]
+		if strings.Count(got, tt.want) != 1 {
+			t.Errorf("%s does not contain %q once", tt.name, tt.want)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	names := func(m *protogen.Message) []string {
+		var names []string
+		for _, f := range m.Fields {
+			names = append(names, string(f.Desc.Name()))
+		}
+		return names
+	}
+	var file *protogen.File
+	for _, f := range examplePlugin(t, "").Files {
+		if f.Desc.Path() == "example1/presence.proto" {
+			file = f
+		}
+	}
+	o := &GenOpts{Sort: sortName}
+	o.sortDeclarations(file)
+	if got, want := []string{file.Messages[0].GoIdent.GoName, file.Messages[1].GoIdent.GoName}, []string{"AnotherMessage", "MyMessage"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("messages sorted by name = %q, want %q", got, want)
+	}
+	if got, want := names(file.Messages[1]), []string{"label", "not_tracked", "tracked"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fields sorted by name = %q, want %q", got, want)
+	}
+	if got, want := names(file.Messages[0]), []string{"id", "my_message", "my_string"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fields sorted by name = %q, want %q", got, want)
+	}
+	if oneof := file.Messages[0].Oneofs[0]; len(oneof.Fields) != 2 || oneof.Fields[0].Oneof != oneof {
+		t.Errorf("oneof %s lost its fields", oneof.Desc.Name())
+	}
+
+	o.Sort = sortNumber
+	o.sortDeclarations(file)
+	if got, want := names(file.Messages[1]), []string{"not_tracked", "tracked", "label"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fields sorted by number = %q, want %q", got, want)
+	}
+
+	md := generateExamples(t, GenOpts{Format: "markdown", Sort: sortName})["example1/booking.md"]
+	if i, j := strings.Index(md, "| description | 2 |"), strings.Index(md, "| id | 1 |"); i < 0 || j < i {
+		t.Errorf("BookingStatus fields are not sorted by name:\n%s", md)
+	}
+
+	o = &GenOpts{Sort: "size"}
+	if err := o.generate(examplePlugin(t, "")); err == nil {
+		t.Error("generate with an invalid sort succeeded")
+	}
+}
+
+func TestCustomOptions(t *testing.T) {
+	var file *protogen.File
+	for _, f := range examplePlugin(t, "").Files {
+		if f.Desc.Path() == "example1/options.proto" {
+			file = f
+		}
+	}
+	if file == nil {
+		t.Fatal("example1/options.proto not found")
+	}
+	event := exampleMessage(t, "com.example.options.Event")
+	category := file.Enums[len(file.Enums)-1]
+	tests := []struct {
+		desc protoreflect.Descriptor
+		want []CustomOption
+	}{
+		{file.Services[0].Desc, []CustomOption{{"com.example.options.owner", `{team: "audit" contact: "audit@example.com"}`}}},
+		{file.Services[0].Methods[0].Desc, []CustomOption{{"com.example.options.tags", `["audit", "read"]`}}},
+		{event.Desc, []CustomOption{{"com.example.options.visibility", "INTERNAL"}}},
+		{event.Fields[0].Desc, []CustomOption{{"com.example.options.sensitive", "true"}}},
+		{event.Fields[1].Desc, []CustomOption{{"com.example.options.unit", `"ms"`}}},
+		{event.Fields[2].Desc, nil},
+		{category.Desc, []CustomOption{{"com.example.options.closed", "true"}}},
+		{category.Values[1].Desc, []CustomOption{{"com.example.options.label", `"Sign in"`}}},
+		{exampleMessage(t, "com.example.booking.Booking").Desc, nil},
+	}
+	for _, tt := range tests {
+		if got := customOptions(tt.desc); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("customOptions(%s) = %q, want %q", tt.desc.FullName(), got, tt.want)
+		}
+	}
+}
+
+func TestValidationRules(t *testing.T) {
+	signup := exampleMessage(t, "com.example.validation.SignupRequest")
+	want := []string{
+		"max length 254, email",
+		"min length 1, max length 64",
+		`pattern "^[a-z0-9_]+$"`,
+		">= 13, < 150",
+		"min 1 items, max 5 items, unique items, items (min length 1)",
+		"required",
+		"defined values only, none of [PLAN_UNSPECIFIED]",
+		"> 1m0s, <= 24h0m0s",
+		"",
+	}
+	var got []string
+	for _, f := range signup.Fields {
+		got = append(got, validationRules(f))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("validationRules = %q, want %q", got, want)
+	}
+	if !hasValidationRules(signup) || hasValidationRules(exampleMessage(t, "com.example.booking.Booking")) {
+		t.Error("hasValidationRules is wrong")
+	}
+}
+
+func TestNestedMessages(t *testing.T) {
+	var names []string
+	for _, m := range nestedMessages(exampleMessage(t, "com.example.maps.Resource")) {
+		names = append(names, string(m.Desc.Name()))
+	}
+	if len(names) != 0 {
+		t.Errorf("nestedMessages(Resource) = %q, want no map entries", names)
+	}
+
+	files := generateExamples(t, GenOpts{Format: "markdown"})
+	content := files["example1/nested.md"]
+	// Inner refers back to Outer, which must not document it again.
+	if n := strings.Count(content, "\n### Outer.Middle.Inner\n"); n != 1 {
+		t.Errorf("example1/nested.md documents Outer.Middle.Inner %d times, want once:\n%s", n, content)
+	}
+	for _, want := range []string{
+		`<a name="com-example-nested-Outer-Middle-Inner"></a>`,
+		"| middle | 1 | middle |  |[Outer.Middle](#com-example-nested-Outer-Middle)|",
+		"| outer | 2 | outer |  |[Outer](#com-example-nested-Outer)|",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("example1/nested.md does not contain %q:\n%s", want, content)
+		}
+	}
+	if content := files["example1/maps.md"]; strings.Contains(content, "AnnotationsEntry") {
+		t.Errorf("example1/maps.md documents the map entry of annotations:\n%s", content)
+	}
+}
+
+func TestPruneExcluded(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "json"})
+	got := files["example1/exclude.json"]
+	for _, name := range []string{"MigrateAccount", "AdminService", "password_hash", "revision", "service", "shard", "internal", "AccountRecord", "Tier"} {
+		if strings.Contains(got, `"name": "`+name+`"`) {
+			t.Errorf("excluded %s was documented", name)
+		}
+	}
+
+	// Fields of excluded types name the type without linking to it.
+	files = generateExamples(t, GenOpts{Format: "markdown"})
+	content := files["example1/exclude.md"]
+	for _, want := range []string{
+		"| record | 1 | record |  |AccountRecord|",
+		"| tier | 2 | tier |  |Tier|",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("example1/exclude.md does not contain %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestCommentFuncs(t *testing.T) {
+	status := exampleMessage(t, "com.example.booking.BookingStatus")
+	if got, _ := leadingComment(status); got != "Represents the status of a vehicle booking." {
+		t.Errorf("leading_comment of BookingStatus = %q", got)
+	}
+	if got, _ := trailingComment(status.Fields[1]); got != `Booking status description. E.g. "Active".` {
+		t.Errorf("trailing_comment of description = %q", got)
+	}
+	if got, _ := leadingComment(status.Fields[1]); got != "" {
+		t.Errorf("leading_comment of description = %q, want none", got)
+	}
+
+	c := protogen.CommentSet{
+		LeadingDetached: []protogen.Comments{" Section one.\n", " @exclude internal notes\n", "\n"},
+		Leading:         " @exclude\n",
+		Trailing:        " Hidden.\n",
+	}
+	if got, _ := trailingComment(c); got != "" {
+		t.Errorf("trailing_comment of an excluded declaration = %q, want none", got)
+	}
+	if got, _ := detachedComments(c); !reflect.DeepEqual(got, []string{"Section one."}) {
+		t.Errorf("detached_comments = %q, want [Section one.]", got)
+	}
+	if _, err := leadingComment("text"); err == nil {
+		t.Error("leading_comment of a string succeeded")
+	}
+}
+
+func TestIncludesPackage(t *testing.T) {
+	tests := []struct {
+		include, exclude []string
+		pkg              protoreflect.FullName
+		want             bool
+	}{
+		{nil, nil, "com.acme.billing", true},
+		{[]string{"com.acme.*"}, nil, "com.acme.billing", true},
+		{[]string{"com.acme.*"}, nil, "com.other", false},
+		{nil, []string{"com.acme.internal.*"}, "com.acme.internal.audit", false},
+		{nil, []string{"com.acme.internal.*"}, "com.acme.internal", false},
+		{nil, []string{"com.acme.internal.*"}, "com.acme.internals", true},
+		{[]string{"com.acme.*"}, []string{"com.acme.internal.*"}, "com.acme.internal.audit", false},
+		{[]string{"com.acme.billing", "com.acme.users"}, nil, "com.acme.users", true},
+	}
+	for _, tt := range tests {
+		o := &GenOpts{IncludePackages: tt.include, ExcludePackages: tt.exclude}
+		if got := o.includesPackage(tt.pkg); got != tt.want {
+			t.Errorf("includesPackage(%q) with include %q and exclude %q = %v, want %v", tt.pkg, tt.include, tt.exclude, got, tt.want)
+		}
+	}
+}
+
+func TestPackageFilter(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown", IncludePackages: []string{"com.example.*"}, ExcludePackages: []string{"com.example.booking", "com.example.r*"}})
+	for _, name := range []string{"example1/booking.md", "example1/rest.md"} {
+		if _, ok := files[name]; ok {
+			t.Errorf("%s was generated", name)
+		}
+	}
+	for _, name := range []string{"example1/maps.md", "example1/imports.md", "example1/vehicle.md"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("%s was not generated", name)
+		}
+	}
+
+	gen := examplePlugin(t, "")
+	o := &GenOpts{Format: "markdown", ExcludePackages: []string{"com.[acme"}}
+	if err := o.generate(gen); err == nil {
+		t.Error("generate with a malformed package pattern succeeded")
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"google/*", "google/api.proto", true},
+		{"google/*", "google/api/http.proto", false},
+		{"vendor/**", "vendor/acme/v1/user.proto", true},
+		{"vendor/**", "vendored/user.proto", false},
+		{"**/internal.proto", "internal.proto", true},
+		{"**/internal.proto", "acme/v1/internal.proto", true},
+		{"acme/**/user.proto", "acme/user.proto", true},
+		{"acme/**/user.proto", "acme/v1/beta/user.proto", true},
+		{"user?.proto", "user2.proto", true},
+		{"user[0-9].proto", "userx.proto", false},
+		{"user[^0-9].proto", "userx.proto", true},
+		{`a\*b.proto`, "a*b.proto", true},
+		{"com.acme.*", "com.acme.billing", true},
+		{"com.acme.*", "comxacme.billing", false},
+	}
+	for _, tt := range tests {
+		re, err := globRegexp(tt.pattern)
+		if err != nil {
+			t.Errorf("globRegexp(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := re.MatchString(tt.name); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+	for _, pattern := range []string{"user[0-9.proto", `trailing\`} {
+		if _, err := globRegexp(pattern); err == nil {
+			t.Errorf("globRegexp(%q) succeeded", pattern)
+		}
+	}
+}
+
+func TestFileFilter(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown", IncludeFiles: []string{"example1/**"}, ExcludeFiles: []string{"**/b*.proto", "com.example.rest"}})
+	for _, name := range []string{"example1/booking.md", "example1/rest.md"} {
+		if _, ok := files[name]; ok {
+			t.Errorf("%s was generated", name)
+		}
+	}
+	for _, name := range []string{"example1/maps.md", "example1/vehicle.md"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("%s was not generated", name)
+		}
+	}
+
+	files = generateExamples(t, GenOpts{Format: "markdown", IncludeFiles: []string{"com.example.maps"}})
+	if len(files) != 1 {
+		t.Errorf("generated %d files including com.example.maps, want 1", len(files))
+	}
+
+	gen := examplePlugin(t, "")
+	o := &GenOpts{Format: "markdown", ExcludeFiles: []string{"vendor/[**"}}
+	if err := o.generate(gen); err == nil {
+		t.Error("generate with a malformed file pattern succeeded")
+	}
+}
+
+func TestSkipEmptyServices(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown", SkipEmptyServices: true})
+	var got []string
+	for name := range files {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	want := []string{"example1/accounts.md", "example1/booking.md", "example1/catalog.md", "example1/deprecated.md", "example1/exclude.md", "example1/options.md", "example1/rest.md"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("generated %q, want %q", got, want)
+	}
+
+	// When combining, vehicle.proto is kept since catalog.proto references
+	// its messages.
+	files = generateExamples(t, GenOpts{Format: "markdown", Combine: true, SkipEmptyServices: true})
+	combined := files["api.md"]
+	for _, name := range []string{"CatalogService", "Manufacturer", "Model"} {
+		if !strings.Contains(combined, name) {
+			t.Errorf("api.md does not document %s", name)
+		}
+	}
+	for _, name := range []string{"Resource", "MyMessage", "Outer"} {
+		if strings.Contains(combined, name) {
+			t.Errorf("api.md documents %s of a file without services", name)
+		}
+	}
+
+	files = generateExamples(t, GenOpts{Format: "markdown", Split: splitService, SkipEmptyServices: true})
+	if _, ok := files["example1/vehicle.types.md"]; !ok {
+		t.Error("example1/vehicle.types.md was not generated")
+	}
+	if _, ok := files["example1/maps.types.md"]; ok {
+		t.Error("example1/maps.types.md was generated")
+	}
+}
+
+func TestContent(t *testing.T) {
+	for _, tt := range []struct {
+		opts        GenOpts
+		name        string
+		want, avoid []string
+	}{
+		{
+			GenOpts{Format: "markdown", Content: contentServices}, "example1/rest.md",
+			[]string{
+				"- [ShelfService](#com-example-rest-ShelfService)\n\n",
+				"[GetShelfRequest](#com-example-rest-ShelfService-GetShelf)",
+				"#### GetShelf\n\nRequest: `com.example.rest.GetShelfRequest`\n\n- `id` string: The shelf ID.\n",
+				"Response (stream): `com.example.rest.Shelf`",
+				"- `books` repeated Shelf.Book: The books on the shelf.",
+			},
+			[]string{"### GetShelfRequest", "### Shelf\n"},
+		},
+		{
+			GenOpts{Format: "markdown", Content: contentMessages}, "example1/rest.md",
+			[]string{"### GetShelfRequest", "### Shelf\n"},
+			[]string{"### ShelfService", "#### GetShelf"},
+		},
+		{
+			GenOpts{Format: "hugo-markdown", Content: contentServices}, "example1/rest.md",
+			[]string{"#### GetShelf\n"},
+			[]string{"### GetShelfRequest"},
+		},
+		{
+			GenOpts{Format: "html", Content: contentServices}, "example1/rest.html",
+			[]string{`<section id="com-example-rest-ShelfService-GetShelf">`, "<li><code>library</code> string: The library the shelf is in.</li>"},
+			[]string{`<section id="com-example-rest-Shelf">`},
+		},
+	} {
+		got := generateExamples(t, tt.opts)[tt.name]
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s with content=%s does not contain %q:\n%s", tt.opts.Format, tt.opts.Content, w, got)
+			}
+		}
+		for _, a := range tt.avoid {
+			if strings.Contains(got, a) {
+				t.Errorf("%s with content=%s contains %q", tt.opts.Format, tt.opts.Content, a)
+			}
+		}
+	}
+
+	for _, o := range []*GenOpts{
+		{Format: "markdown", Content: "rpcs"},
+		{Format: "html", Content: contentServices, Split: splitPage},
+	} {
+		if err := o.generate(examplePlugin(t, "")); err == nil {
+			t.Errorf("generate with content=%s and split=%s succeeded", o.Content, o.Split)
+		}
+	}
+}
+
+func TestHTTPRules(t *testing.T) {
+	want := map[string][]HTTPRule{
+		"GetShelf": {
+			{Method: "GET", Path: "/v1/shelves/{id}"},
+			{Method: "GET", Path: "/v1/libraries/{library}/shelves/{id}"},
+		},
+		"CreateShelf": {{Method: "POST", Path: "/v1/shelves", Body: "*"}},
+		"CheckShelf":  {{Method: "HEAD", Path: "/v1/shelves/{id}"}},
+		"WatchShelf":  {},
+		"ImportBooks": {},
+		"SyncShelf":   {},
+	}
+	for _, f := range examplePlugin(t, "").Files {
+		if f.Desc.Path() != "example1/rest.proto" {
+			continue
+		}
+		for _, m := range f.Services[0].Methods {
+			if got := httpRules(m); !reflect.DeepEqual(got, want[m.GoName]) {
+				t.Errorf("httpRules(%v) = %+v, want %+v", m.GoName, got, want[m.GoName])
+			}
+		}
+	}
+}
+
+func TestOpenAPIGolden(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "openapi"})
+	name := "example1/rest.openapi.yaml"
+	content, ok := files[name]
+	if !ok {
+		t.Fatalf("%s was not generated", name)
+	}
+	checkGolden(t, name, content)
+}
+
+func TestOpenAPIInfo(t *testing.T) {
+	tests := []struct {
+		opts                     GenOpts
+		name, title, description string
+	}{
+		{GenOpts{Format: "openapi"}, "example1/rest.openapi.yaml", "com.example.rest", "API Specification for the com.example.rest package."},
+		{GenOpts{Format: "openapi", Title: "Shelf API", Description: "Shelves and their books."}, "example1/rest.openapi.yaml", "Shelf API", "Shelves and their books."},
+		{GenOpts{Format: "openapi", Title: "Shelf API", Description: "Shelves and their books.", Combine: true}, "api.openapi.yaml", "Shelf API", "Shelves and their books."},
+	}
+	for _, tt := range tests {
+		var doc openAPIDocument
+		if err := yaml.Unmarshal([]byte(generateExamples(t, tt.opts)[tt.name]), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.Info.Title != tt.title || doc.Info.Description != tt.description {
+			t.Errorf("%s with title %q and description %q has info %+v", tt.name, tt.opts.Title, tt.opts.Description, doc.Info)
+		}
+	}
+}
+
+func TestOpenAPIJSON(t *testing.T) {
+	content, ok := generateExamples(t, GenOpts{Format: "openapi-json"})["example1/rest.openapi.json"]
+	if !ok {
+		t.Fatal("example1/rest.openapi.json was not generated")
+	}
+	var got, want interface{}
+	if err := json.Unmarshal([]byte(content), &got); err != nil {
+		t.Fatalf("example1/rest.openapi.json is not valid JSON: %v", err)
+	}
+	// The JSON document holds the same as the YAML one.
+	var doc interface{}
+	if err := yaml.Unmarshal([]byte(generateExamples(t, GenOpts{Format: "openapi"})["example1/rest.openapi.yaml"]), &doc); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("example1/rest.openapi.json differs from example1/rest.openapi.yaml:\n%s", content)
+	}
+}
+
+func TestOpenAPIErrors(t *testing.T) {
+	var rest *protogen.File
+	for _, f := range examplePlugin(t, "").Files {
+		if f.Desc.Path() == "example1/rest.proto" {
+			rest = f
+		}
+	}
+	s := rest.Services[0]
+	b := &openAPIBuilder{schemas: make(map[string]*openAPISchema)}
+	for _, r := range []HTTPRule{
+		{Method: "POST", Path: "/v1/shelves", Body: "missing"},
+		{Method: "GET", Path: "/v1/shelves", ResponseBody: "missing"},
+	} {
+		if _, err := b.operation(s, s.Methods[0], r); err == nil {
+			t.Errorf("operation of %+v succeeded", r)
+		}
+	}
+
+	// Documenting the file twice binds every path twice.
+	o := &GenOpts{Format: "openapi"}
+	if _, err := o.openAPIDocument(&TemplateData{Files: []*protogen.File{rest, rest}}); err == nil || !strings.Contains(err.Error(), "is also bound to") {
+		t.Errorf("openAPIDocument with duplicate bindings returned %v", err)
+	}
+}
+
+func TestPostmanGolden(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "postman"})
+	name := "example1/rest.postman_collection.json"
+	content, ok := files[name]
+	if !ok {
+		t.Fatalf("%s was not generated", name)
+	}
+	checkGolden(t, name, content)
+
+	var c postmanCollection
+	if err := json.Unmarshal([]byte(content), &c); err != nil {
+		t.Fatalf("%s is not valid JSON: %v", name, err)
+	}
+	if c.Info.Name == "" || c.Info.Schema != postmanSchema {
+		t.Errorf("info = %+v, want a name and schema %q", c.Info, postmanSchema)
+	}
+	if len(c.Item) == 0 {
+		t.Fatalf("%s has no folders", name)
+	}
+	for _, folder := range c.Item {
+		for _, item := range folder.Item {
+			if item.Request == nil || item.Request.Method == "" || item.Request.URL.Raw == "" {
+				t.Errorf("%s/%s has an incomplete request: %+v", folder.Name, item.Name, item.Request)
+			}
+		}
+	}
+	get := c.Item[0].Item[0].Request
+	if want := []postmanQuery{{Key: "library", Value: "", Description: "The library the shelf is in.", Disabled: true}}; !reflect.DeepEqual(get.URL.Query, want) {
+		t.Errorf("query of GetShelf = %+v, want %+v", get.URL.Query, want)
+	}
+}
+
+func TestSlate(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "slate"})
+	got := files["example1/rest.html.md"]
+	for _, want := range []string{
+		"---\ntitle: com.example.rest\nlanguage_tabs:\n  - json\nsearch: true\n---\n",
+		"\n# ShelfService\n",
+		"## CreateShelf\n\n```json\n{\n  \"id\": \"\",\n  \"theme\": \"\",\n  \"books\": [\n    {\n      \"title\": \"\",\n      \"pageCount\": \"0\"\n    }\n  ]\n}\n```\n",
+		"`POST /v1/shelves` (body: `*`)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("example1/rest.html.md does not contain %q:\n%s", want, got)
+		}
+	}
+	files = generateExamples(t, GenOpts{Format: "slate", Combine: true})
+	if got := files["api.html.md"]; !strings.HasPrefix(got, "---\ntitle: API Reference\n") {
+		t.Errorf("api.html.md does not start with the slate front matter:\n%s", got)
+	}
+
+	// Configured front matter fields are added to the Slate ones, which
+	// Slate needs.
+	files = generateExamples(t, GenOpts{Format: "slate", FrontMatter: []string{"title=Shelves", "weight=3"}})
+	want := "---\ntitle: Shelves\nlanguage_tabs:\n  - json\nsearch: true\nproto_file: example1/rest.proto\nweight: 3\n---\n"
+	if got := files["example1/rest.html.md"]; !strings.HasPrefix(got, want) {
+		t.Errorf("example1/rest.html.md does not start with %q:\n%s", want, got)
+	}
+}
+
+func TestCSVGolden(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "csv"})
+	name := "example1/booking.csv"
+	content, ok := files[name]
+	if !ok {
+		t.Fatalf("%s was not generated", name)
+	}
+	checkGolden(t, name, content)
+
+	records, err := csv.NewReader(strings.NewReader(content)).ReadAll()
+	if err != nil {
+		t.Fatalf("%s is not valid CSV: %v", name, err)
+	}
+	if !reflect.DeepEqual(records[0], csvHeader) {
+		t.Errorf("header = %q, want %q", records[0], csvHeader)
+	}
+	want := []string{"com.example.booking.BookingStatus", "description", "string", "", "2", "false", `Booking status description. E.g. "Active".`}
+	found := false
+	for _, r := range records[1:] {
+		found = found || reflect.DeepEqual(r, want)
+	}
+	if !found {
+		t.Errorf("%s has no record %q:\n%s", name, want, content)
+	}
+}
+
+func TestDotGolden(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "dot"})
+	for _, tt := range []struct {
+		name         string
+		nodes, edges int
+		want         string
+	}{
+		{"example1/booking.dot", 5, 5, `"com-example-booking-BookingService" [label="BookingService", shape=component];`},
+		{"example1/maps.dot", 3, 2, `"com-example-maps-Resource" -> "com-example-maps-Label" [label="labels", style=dashed];`},
+		{"example1/imports.dot", 4, 3, `"com-example-booking-Booking" [label="com.example.booking.Booking", shape=note];`},
+	} {
+		content, ok := files[tt.name]
+		if !ok {
+			t.Errorf("%s was not generated", tt.name)
+			continue
+		}
+		checkGolden(t, tt.name, content)
+		nodes, edges := countDot(content)
+		if nodes != tt.nodes || edges != tt.edges {
+			t.Errorf("%s has %d nodes and %d edges, want %d and %d:\n%s", tt.name, nodes, edges, tt.nodes, tt.edges, content)
+		}
+		if !strings.Contains(content, tt.want) {
+			t.Errorf("%s does not contain %q:\n%s", tt.name, tt.want, content)
+		}
+	}
+}
+
+// countDot returns the number of nodes and edges of a digraph written by
+// renderDot.
+func countDot(content string) (nodes, edges int) {
+	for _, line := range strings.Split(content, "\n") {
+		switch {
+		case strings.Contains(line, " -> "):
+			edges++
+		case strings.Contains(line, "shape="):
+			nodes++
+		}
+	}
+	return nodes, edges
+}
+
+func TestDotWKT(t *testing.T) {
+	for _, tt := range []struct {
+		wkt          string
+		nodes, edges int
+		want         string
+	}{
+		{dotWKTKeep, 4, 3, `"google-protobuf-Timestamp" [label="google.protobuf.Timestamp", shape=note];`},
+		{dotWKTCollapse, 3, 3, `"com-example-imports-Reservation" -> "google-protobuf" [label="hold"];`},
+		{dotWKTOmit, 2, 1, `"com-example-imports-Reservation" -> "com-example-booking-Booking" [label="booking"];`},
+	} {
+		t.Run(tt.wkt, func(t *testing.T) {
+			content := generateExamples(t, GenOpts{Format: "dot", DotWKT: tt.wkt})["example1/imports.dot"]
+			nodes, edges := countDot(content)
+			if nodes != tt.nodes || edges != tt.edges {
+				t.Errorf("got %d nodes and %d edges, want %d and %d:\n%s", nodes, edges, tt.nodes, tt.edges, content)
+			}
+			if !strings.Contains(content, tt.want) {
+				t.Errorf("output does not contain %q:\n%s", tt.want, content)
+			}
+		})
+	}
+
+	o := &GenOpts{Format: "dot", DotWKT: "hide"}
+	if err := o.generate(examplePlugin(t, "")); err == nil {
+		t.Error("dot_wkt=hide was accepted")
+	}
+}
+
+func TestMessageRefs(t *testing.T) {
+	var got []string
+	for _, ref := range messageRefs(exampleMessage(t, "com.example.maps.Resource")) {
+		got = append(got, fmt.Sprintf("%s %s enum=%v many=%v", ref.Field.Desc.Name(), ref.Target, ref.Enum, ref.Many))
+	}
+	want := []string{
+		"labels com.example.maps.Label enum=false many=true",
+		"statuses com.example.maps.Status enum=true many=true",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("messageRefs(Resource) = %q, want %q", got, want)
+	}
+}
+
+func TestPlantUMLGolden(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "plantuml"})
+	for _, name := range []string{"example1/maps.puml", "example1/nested.puml"} {
+		content, ok := files[name]
+		if !ok {
+			t.Errorf("%s was not generated", name)
+			continue
+		}
+		checkGolden(t, name, content)
+		if !strings.HasPrefix(content, "@startuml\n") || !strings.HasSuffix(content, "@enduml\n") {
+			t.Errorf("%s is not a single @startuml/@enduml diagram:\n%s", name, content)
+		}
+	}
+}
+
+func TestMermaidGolden(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "mermaid"})
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"example1/maps.mmd", `com_example_maps_Resource ..> "*" com_example_maps_Status : statuses`},
+		{"example1/imports.mmd", `class google_protobuf_Timestamp["google.protobuf.Timestamp"]` + "\n"},
+		// middle and previous share a single arrow.
+		{"example1/nested.mmd", `com_example_nested_Outer --> "*" com_example_nested_Outer_Middle : middle, previous`},
+	} {
+		content, ok := files[tt.name]
+		if !ok {
+			t.Errorf("%s was not generated", tt.name)
+			continue
+		}
+		checkGolden(t, tt.name, content)
+		if !strings.HasPrefix(content, "classDiagram\n") {
+			t.Errorf("%s is not a class diagram:\n%s", tt.name, content)
+		}
+		if !strings.Contains(content, tt.want) {
+			t.Errorf("%s does not contain %q:\n%s", tt.name, tt.want, content)
+		}
+	}
+}
+
+func TestMermaidMarkdown(t *testing.T) {
+	const fence = "```mermaid\nclassDiagram\n"
+	if content := generateExamples(t, GenOpts{Format: "markdown"})["example1/maps.md"]; strings.Contains(content, fence) {
+		t.Errorf("maps.md embeds a diagram without mermaid:\n%s", content)
+	}
+	files := generateExamples(t, GenOpts{Format: "markdown", Mermaid: true})
+	content := files["example1/maps.md"]
+	diagram := generateExamples(t, GenOpts{Format: "mermaid"})["example1/maps.mmd"]
+	if !strings.Contains(content, "```mermaid\n"+diagram+"```\n") {
+		t.Errorf("maps.md does not embed the diagram of maps.mmd:\n%s", content)
+	}
+	if i, j := strings.Index(content, fence), strings.Index(content, "### Label"); i < 0 || j < i {
+		t.Errorf("maps.md does not embed the diagram before the messages:\n%s", content)
+	}
+	// Documents of services only leave the diagram out along with messages.
+	if content := generateExamples(t, GenOpts{Format: "markdown", Mermaid: true, Content: contentServices})["example1/maps.md"]; strings.Contains(content, "```mermaid") {
+		t.Errorf("maps.md embeds a diagram with content=services:\n%s", content)
+	}
+
+	content = generateExamples(t, GenOpts{Format: "markdown", Mermaid: true, GroupBy: groupPackage})["example1/com.example.maps.md"]
+	if !strings.Contains(content, "```mermaid\n"+diagram+"```\n") {
+		t.Errorf("com.example.maps.md does not embed the diagram of maps.mmd:\n%s", content)
+	}
+}
+
+func TestReferencedTypes(t *testing.T) {
+	names := func(refs ReferencedTypes) []string {
+		var names []string
+		for _, m := range refs.Messages {
+			names = append(names, string(m.Desc.FullName()))
+		}
+		for _, e := range refs.Enums {
+			names = append(names, string(e.Desc.FullName()))
+		}
+		return names
+	}
+	for _, tt := range []struct {
+		root protoreflect.FullName
+		want []string
+	}{
+		// A message referencing itself is among its referenced types.
+		{"com.example.recursive.Node", []string{"com.example.recursive.Node", "com.example.recursive.Shape"}},
+		// Person and Team reference each other.
+		{"com.example.recursive.Person", []string{"com.example.recursive.Team", "com.example.recursive.Person"}},
+		{"com.example.recursive.Team", []string{"com.example.recursive.Person", "com.example.recursive.Team"}},
+		// Outer references Inner through Middle, which references Outer.
+		{"com.example.nested.Outer", []string{"com.example.nested.Outer.Middle", "com.example.nested.Outer.Middle.Inner", "com.example.nested.Outer", "com.example.nested.Outer.Middle.Inner.Depth"}},
+		// Map fields reference their value types, not their entries.
+		{"com.example.maps.Resource", []string{"com.example.maps.Label", "com.example.maps.Status"}},
+		{"com.example.maps.Label", nil},
+	} {
+		if got := names(referencedTypes(exampleMessage(t, tt.root))); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("referencedTypes(%s) = %q, want %q", tt.root, got, tt.want)
+		}
+	}
+
+	// Walking both messages of a cycle visits each once.
+	w := newTypeWalker(false)
+	w.walk(exampleMessage(t, "com.example.recursive.Person"))
+	w.walk(exampleMessage(t, "com.example.recursive.Team"))
+	if got, want := names(ReferencedTypes{w.messages, w.enums}), []string{"com.example.recursive.Person", "com.example.recursive.Team"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %q, want %q", got, want)
+	}
+}
+
+func TestStreamingKind(t *testing.T) {
+	want := map[string]string{
+		"GetShelf":    "unary",
+		"WatchShelf":  "server streaming",
+		"ImportBooks": "client streaming",
+		"SyncShelf":   "bidirectional streaming",
+	}
+	for _, f := range examplePlugin(t, "").Files {
+		if f.Desc.Path() != "example1/rest.proto" {
+			continue
+		}
+		for _, m := range f.Services[0].Methods {
+			if w, ok := want[m.GoName]; ok && streamingKind(m) != w {
+				t.Errorf("streamingKind(%v) = %q, want %q", m.GoName, streamingKind(m), w)
+			}
+		}
+	}
+}
+
+func TestMethodInputOutput(t *testing.T) {
+	var importBooks *protogen.Method
+	for _, f := range examplePlugin(t, "").Files {
+		if f.Desc.Path() == "example1/rest.proto" {
+			importBooks = f.Services[0].Methods[4]
+		}
+	}
+	if importBooks == nil || importBooks.GoName != "ImportBooks" {
+		t.Fatalf("ImportBooks not found, got %v", importBooks)
+	}
+	if in, out := methodInput(importBooks), methodOutput(importBooks); in != importBooks.Input || out != importBooks.Output {
+		t.Errorf("methodInput and methodOutput = %v and %v, want %v and %v", in, out, importBooks.Input, importBooks.Output)
+	}
+	o := &GenOpts{}
+	if got, want := o.methodInputAnchor(importBooks), "com-example-rest-Shelf-Book"; got != want {
+		t.Errorf("methodInputAnchor = %q, want %q", got, want)
+	}
+	if got, want := o.methodOutputAnchor(importBooks), "com-example-rest-Shelf"; got != want {
+		t.Errorf("methodOutputAnchor = %q, want %q", got, want)
+	}
+
+	// Method tables name nested request and response messages by their long
+	// names.
+	for _, tt := range []struct {
+		format, name, want string
+	}{
+		{"markdown", "example1/rest.md", "| ImportBooks | [Shelf.Book](#com-example-rest-Shelf-Book) | [Shelf](#com-example-rest-Shelf) | client streaming |"},
+		{"asciidoc", "example1/rest.adoc", "<<com-example-rest-Shelf-Book,Shelf.Book>>"},
+		{"rst", "example1/rest.rst", ":ref:`Shelf.Book <com-example-rest-Shelf-Book>`"},
+		{"man", "example1/rest.7", "rpc ImportBooks(stream Shelf.Book) returns (Shelf)"},
+	} {
+		content := generateExamples(t, GenOpts{Format: tt.format})[tt.name]
+		if !strings.Contains(content, tt.want) {
+			t.Errorf("%s does not contain %q:\n%s", tt.name, tt.want, content)
+		}
+	}
+}
+
+func TestModelGolden(t *testing.T) {
+	for _, format := range []string{"json", "yaml"} {
+		t.Run(format, func(t *testing.T) {
+			files := generateExamples(t, GenOpts{Format: format})
+			if len(files) == 0 {
+				t.Fatal("no files generated")
+			}
+			for name, content := range files {
+				checkGolden(t, name, content)
+			}
+		})
+	}
+}
+
+func TestMapModel(t *testing.T) {
+	m := newMessageModel(exampleMessage(t, "com.example.maps.Resource"))
+	labels := m.Fields[1]
+	if labels.Name != "labels" || labels.Label != "map" || labels.MapKeyType != "string" || labels.MapValueType != "com.example.maps.Label" {
+		t.Errorf("model of the labels map field = %+v, want label map, key string and value com.example.maps.Label", labels)
+	}
+	if labels.Type != "map<string, Label>" || labels.FullType != "map<string, com.example.maps.Label>" {
+		t.Errorf("model of the labels map field has type %q and full type %q", labels.Type, labels.FullType)
+	}
+	// The entry messages of map fields aren't nested messages.
+	for _, nested := range m.Messages {
+		if strings.HasSuffix(nested.Name, "Entry") {
+			t.Errorf("model of %s has the map entry message %s", m.FullName, nested.FullName)
+		}
+	}
+	aliases := newMessageModel(exampleMessage(t, "com.example.maps.Label")).Fields[1]
+	if aliases.Label != "repeated" || aliases.MapKeyType != "" || aliases.MapValueType != "" {
+		t.Errorf("model of the aliases repeated field = %+v, want label repeated and no map types", aliases)
+	}
+}
+
+func TestPFilter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want htmltemplate.HTML
+	}{
+		{"one paragraph", "<p>one paragraph</p>"},
+		{"first\n\nsecond", "<p>first</p><p>second</p>"},
+		{"use <id> & <name>", "<p>use &lt;id&gt; &amp; &lt;name&gt;</p>"},
+		{
+			" Create a booking:\n ```json\n {\n   \"id\": 1\n }\n ```\n Returns the booking.\n",
+			"<p>Create a booking:</p><pre><code class=\"language-json\">{\n  &#34;id&#34;: 1\n}</code></pre><p>Returns the booking.</p>",
+		},
+		{"Unclosed:\n~~~\na <b>\n\nb", "<p>Unclosed:</p><pre><code>a &lt;b&gt;\n\nb</code></pre>"},
+	}
+	for _, tt := range tests {
+		if got := pFilter(tt.in); got != tt.want {
+			t.Errorf("pFilter(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNobrFilter(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"first\nline\n\nsecond", "first line\n\nsecond"},
+		{
+			" Create a booking,\n for example:\n ```proto\n Booking{\n   id: 1\n }\n ```\n Returns\n the booking.",
+			"Create a booking, for example:\n\n```proto\nBooking{\n  id: 1\n}\n```\n\nReturns the booking.",
+		},
+		{"~~~~\n```\nnested\n```\n~~~~", "~~~~\n```\nnested\n```\n~~~~"},
+	}
+	for _, tt := range tests {
+		if got := nobrFilter(tt.in); got != tt.want {
+			t.Errorf("nobrFilter(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestInlineFilter(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"first\r\nline\r\n\r\nsecond", "first line second"},
+		{"first\n\n\n\nsecond\n\n\nthird", "first second third"},
+		{" \t leading and trailing \n\n", "leading and trailing"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := inlineFilter(tt.in); got != tt.want {
+			t.Errorf("inlineFilter(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// The comment of label has two paragraphs, which must not break the
+	// row of the field table.
+	files := generateExamples(t, GenOpts{Format: "markdown"})
+	want := "| label | 3 | label |  |[string](#string)|  | Implicit presence, declared after the optional field. Empty labels are not serialized. |\n"
+	if got := files["example1/presence.md"]; !strings.Contains(got, want) {
+		t.Errorf("example1/presence.md does not contain %q:\n%s", want, got)
+	}
+}
+
+func TestAdocEscapeFilter(t *testing.T) {
+	in := "a | b {attr}"
+	want := `a \| b \{attr}`
+	if got := adocEscapeFilter(in); got != want {
+		t.Errorf("adocEscapeFilter(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestAdocParaFilter(t *testing.T) {
+	in := " First line\n continued.\n\n\n Second paragraph.\n"
+	want := "First line continued.\n\nSecond paragraph."
+	if got := adocParaFilter(in); got != want {
+		t.Errorf("adocParaFilter(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestConfluenceEscapeFilter(t *testing.T) {
+	in := "a | b {code}"
+	want := `a \| b \{code\}`
+	if got := confluenceEscapeFilter(in); got != want {
+		t.Errorf("confluenceEscapeFilter(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestStorageParaFilter(t *testing.T) {
+	for in, want := range map[string]string{
+		"a < b\n\nc & d": "<p>a &lt; b</p><p>c &amp; d</p>",
+		"Example:\n```json\n{\"a\": \"]]>\"}\n```": `<p>Example:</p><ac:structured-macro ac:name="code"><ac:parameter ac:name="language">json</ac:parameter>` +
+			`<ac:plain-text-body><![CDATA[{"a": "]]]]><![CDATA[>"}]]></ac:plain-text-body></ac:structured-macro>`,
+	} {
+		if got := storageParaFilter(in); got != want {
+			t.Errorf("storageParaFilter(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestConfluenceStorageGolden(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "confluence-storage"})
+	name := "example1/rest.xhtml"
+	content, ok := files[name]
+	if !ok {
+		t.Fatalf("%s was not generated", name)
+	}
+	checkGolden(t, name, content)
+
+	for name, content := range files {
+		// Pages are fragments, so wrap them in an element to parse them.
+		d := xml.NewDecoder(strings.NewReader("<page>" + content + "</page>"))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("%s is not well-formed: %v", name, err)
+				break
+			}
+		}
+	}
+}
+
+func TestDokuwikiEscapeFilter(t *testing.T) {
+	in := "x^2 | y"
+	want := "x%%^%%2 %%|%% y"
+	if got := dokuwikiEscapeFilter(in); got != want {
+		t.Errorf("dokuwikiEscapeFilter(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestDokuwikiID(t *testing.T) {
+	for in, want := range map[string]string{
+		"Booking":             "booking",
+		"Outer.Middle.Inner":  "outermiddleinner",
+		"com.example.booking": "comexamplebooking",
+		"HTTP Mapping (v2)":   "http_mapping_v2",
+		"2 Legacy__Order":     "legacy_order",
+	} {
+		if got := dokuwikiID(in); got != want {
+			t.Errorf("dokuwikiID(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMarkdownTableOfContents(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown"})
+	content := files["example1/nested.md"]
+	want := "- [Outer](#com-example-nested-Outer)\n  - [Middle](#com-example-nested-Outer-Middle)\n    - [Inner](#com-example-nested-Outer-Middle-Inner)\n"
+	if !strings.Contains(content, want) {
+		t.Errorf("example1/nested.md does not contain %q:\n%s", want, content)
+	}
+	// Every entry links to an anchor of the body.
+	link := regexp.MustCompile(`\]\(#([^)]+)\)`)
+	for name, content := range files {
+		toc := content[strings.Index(content, "## Table of Contents"):strings.Index(content, `<p align="right">`)]
+		for _, m := range link.FindAllStringSubmatch(toc, -1) {
+			if !strings.Contains(content, `<a name="`+m[1]+`"></a>`) {
+				t.Errorf("%s: table of contents links to missing anchor %q", name, m[1])
+			}
+		}
+	}
+}
+
+func TestAnchorSet(t *testing.T) {
+	s := newAnchorSet(nil)
+	got := []string{s.anchor("a/b"), s.anchor("a_b"), s.anchor("a.b"), s.anchor("a-b"), s.anchor("a-b-2")}
+	want := []string{"a_b", "a_b-2", "a-b", "a-b-2", "a-b-2-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("anchors = %q, want %q", got, want)
+	}
+	if got := s.anchor("a_b"); got != "a_b-2" {
+		t.Errorf("second anchor(%q) = %q, want %q", "a_b", got, "a_b-2")
+	}
+
+	// Declarations are registered up front, so their anchors don't depend
+	// on the order templates ask for them.
+	files := examplePlugin(t, "").Files
+	names := []string{"com.example.booking.Booking", "com.example.Vehicle.Category", "com.example.rest.ShelfService.GetShelf", "example1/booking.proto"}
+	forward, backward := newAnchorSet(files), newAnchorSet(files)
+	for i := range names {
+		backward.anchor(names[len(names)-1-i])
+	}
+	for _, name := range names {
+		if got, want := forward.anchor(name), backward.anchor(name); got != want {
+			t.Errorf("anchor(%q) = %q asked first, %q asked last", name, got, want)
+		}
+	}
+}
+
+func TestManEscapeFilter(t *testing.T) {
+	in := ".so /etc/passwd\n'br\nC:\\path"
+	want := "\\&.so /etc/passwd\n\\&'br\nC:\\epath"
+	if got := manEscapeFilter(in); got != want {
+		t.Errorf("manEscapeFilter(%q) = %q, want %q", in, got, want)
+	}
+	in = "First.\n\n.Second."
+	want = "First.\n.PP\n\\&.Second."
+	if got := manParaFilter(".PP", in); got != want {
+		t.Errorf("manParaFilter(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestTexEscapeFilter(t *testing.T) {
+	in := `daily_hire_rate 100% & #1 {a} $x \ ~^`
+	want := `daily\_hire\_rate 100\% \& \#1 \{a\} \$x \textbackslash{} \textasciitilde{}\textasciicircum{}`
+	if got := texEscapeFilter(in); got != want {
+		t.Errorf("texEscapeFilter(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestTextileEscapeFilter(t *testing.T) {
+	in := "a | b"
+	want := "a &#124; b"
+	if got := textileEscapeFilter(in); got != want {
+		t.Errorf("textileEscapeFilter(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestMdEscapeFilter(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"a | b", `a \| b`},
+		{"*required* for **all** calls", `\*required\* for \*\*all\*\* calls`},
+		{"set `name` to the id", "set \\`name\\` to the id"},
+		{`daily_hire_rate, see [docs] or <url>`, `daily\_hire\_rate, see \[docs\] or \<url>`},
+		{`C:\path`, `C:\\path`},
+	}
+	for _, tt := range tests {
+		if got := mdEscapeFilter(tt.in); got != tt.want {
+			t.Errorf("mdEscapeFilter(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	files := generateExamples(t, GenOpts{Format: "markdown"})
+	want := `| STATE_PENDING **Deprecated** | 2 |  Use STATE\_OPEN.  |`
+	if got := files["example1/deprecated.md"]; !strings.Contains(got, want) {
+		t.Errorf("example1/deprecated.md does not contain %q:\n%s", want, got)
+	}
+}
+
+func TestMdxEscapeFilter(t *testing.T) {
+	in := "use <id> or {name}"
+	want := "use &lt;id> or &#123;name&#125;"
+	if got := mdxEscapeFilter(in); got != want {
+		t.Errorf("mdxEscapeFilter(%q) = %q, want %q", in, got, want)
+	}
+}
+
+func TestMdxFrontMatter(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "mdx", SidebarPositionStart: 10})
+	want := "---\nid: example1_booking-proto\ntitle: com.example.booking\nsidebar_label: booking\nsidebar_position: 11\n---\n"
+	if got := files["example1/booking.mdx"]; !strings.HasPrefix(got, want) {
+		t.Errorf("example1/booking.mdx does not start with %q:\n%s", want, got)
+	}
+	want = "sidebar_position: 10\n"
+	if got := files["example1/accounts.mdx"]; !strings.Contains(got, want) {
+		t.Errorf("example1/accounts.mdx does not contain %q:\n%s", want, got)
+	}
+	files = generateExamples(t, GenOpts{Format: "mdx"})
+	if got := files["example1/booking.mdx"]; strings.Contains(got, "sidebar_position") {
+		t.Errorf("example1/booking.mdx has a sidebar_position without mdx_sidebar_position_start:\n%s", got)
+	}
+}
+
+func TestRstTitle(t *testing.T) {
+	want := "Überblick\n---------"
+	if got := rstTitle("Überblick", "-"); got != want {
+		t.Errorf("rstTitle() = %q, want %q", got, want)
+	}
+}
+
+func TestRstParaFilter(t *testing.T) {
+	in := " Represents a booking.\n\n Vehicles are\n quite fun.\n"
+	want := "Represents a booking.\n\n   Vehicles are quite fun."
+	if got := rstParaFilter(3, in); got != want {
+		t.Errorf("rstParaFilter() = %q, want %q", got, want)
+	}
+}
+
+// TestDocBook checks that DocBook articles are well-formed DocBook 5 and
+// that every link within an article points at a section of it. Validating
+// against the DocBook schema requires a RELAX NG validator, which is out of
+// reach of the tests.
+func TestDocBook(t *testing.T) {
+	const xmlNS = "http://www.w3.org/XML/1998/namespace"
+	for name, content := range generateExamples(t, GenOpts{Format: "docbook"}) {
+		var (
+			root     *xml.StartElement
+			ids      = make(map[string]bool)
+			linkends []string
+		)
+		d := xml.NewDecoder(strings.NewReader(content))
+		for {
+			tok, err := d.Token()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("%s is not well-formed: %v", name, err)
+				break
+			}
+			start, ok := tok.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			if root == nil {
+				root = &start
+			}
+			for _, attr := range start.Attr {
+				switch {
+				case attr.Name.Space == xmlNS && attr.Name.Local == "id":
+					if ids[attr.Value] {
+						t.Errorf("%s: duplicate xml:id %q", name, attr.Value)
+					}
+					ids[attr.Value] = true
+				case attr.Name.Local == "linkend":
+					linkends = append(linkends, attr.Value)
+				}
+			}
+		}
+		if root == nil || root.Name.Space != "http://docbook.org/ns/docbook" || root.Name.Local != "article" {
+			t.Errorf("%s: root element is not a DocBook article: %v", name, root)
+			continue
+		}
+		for _, attr := range root.Attr {
+			if attr.Name.Local == "version" && attr.Value != "5.0" {
+				t.Errorf("%s: version = %q, want 5.0", name, attr.Value)
+			}
+		}
+		for _, linkend := range linkends {
+			if !ids[linkend] {
+				t.Errorf("%s: link to missing section %q", name, linkend)
+			}
+		}
+	}
+}
+
+func TestXMLEscapeFilter(t *testing.T) {
+	in := `a < b && c > "d" 'e'`
+	want := "a &lt; b &amp;&amp; c &gt; &quot;d&quot; &apos;e&apos;"
+	if got := xmlEscapeFilter(in); got != want {
+		t.Errorf("xmlEscapeFilter(%q) = %q, want %q", in, got, want)
+	}
+}
+
+// TestCommentEscaping checks that only HTML output escapes the special
+// characters of comments for HTML, while text formats apply their own
+// escaping, if any.
+func TestCommentEscaping(t *testing.T) {
+	tests := []struct {
+		format, name, want string
+	}{
+		{"html", "example1/escaping.html", `e.g. &#34;&lt;id&gt; &amp; &lt;name&gt;&#34;.`},
+		{"docbook", "example1/escaping.xml", `e.g. &quot;&lt;id&gt; &amp; &lt;name&gt;&quot;.`},
+		{"markdown", "example1/escaping.md", `e.g. "\<id> & \<name>".`},
+		{"asciidoc", "example1/escaping.adoc", `e.g. "<id> & <name>".`},
+		{"rst", "example1/escaping.rst", `e.g. "<id> & <name>".`},
+		{"textile", "example1/escaping.textile", `e.g. "<id> & <name>".`},
+	}
+	for _, tt := range tests {
+		got := generateExamples(t, GenOpts{Format: tt.format})[tt.name]
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("%s does not contain %q:\n%s", tt.name, tt.want, got)
+		}
+	}
+
+	md := filepath.Join(t.TempDir(), "query.md.tmpl")
+	text := `{{define "output"}}{{ range .Messages }}{{ range .Fields }}{{ description .Comments.Trailing }}{{ end }}{{ end }}{{end}}`
+	if err := os.WriteFile(md, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got := generateExamples(t, GenOpts{TemplateFile: md})["example1/escaping.md"]
+	if want := `e.g. "<id> & <name>".`; !strings.Contains(got, want) {
+		t.Errorf("example1/escaping.md = %q, want it to contain %q", got, want)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	for _, format := range []string{"markdown", "html", "json"} {
+		t.Run(format, func(t *testing.T) {
+			files := generateExamples(t, GenOpts{Format: format, Combine: true})
+			if len(files) != 1 {
+				t.Fatalf("got %d files, want 1", len(files))
+			}
+			name := "api." + (&GenOpts{Format: format}).fileSuffix()
+			content, ok := files[name]
+			if !ok {
+				t.Fatalf("%s was not generated", name)
+			}
+			for _, want := range []string{"com.example.booking.Booking", "com.example.proto3.MyMessage", "com.example.Vehicle"} {
+				if format != "json" {
+					want = anchor(want)
+				}
+				if !strings.Contains(content, want) {
+					t.Errorf("%s does not contain %q", name, want)
+				}
+			}
+		})
+	}
+	t.Run("unsupported", func(t *testing.T) {
+		gen := examplePlugin(t, "paths=source_relative")
+		opts := GenOpts{Format: "rst", Combine: true}
+		if err := opts.generate(gen); err == nil {
+			t.Error("expected an error for a format without a combined template")
+		}
+	})
+}
+
+func TestStdout(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown", Combine: true, Stdout: true})
+	combined := generateExamples(t, GenOpts{Format: "markdown", Combine: true})["api.md"]
+	if got, ok := files[stdoutFileName]; len(files) != 1 || !ok || got != combined {
+		t.Errorf("got %d files, want the combined document as %s", len(files), stdoutFileName)
+	}
+	for _, o := range []*GenOpts{
+		{Format: "markdown", Stdout: true},
+		{Format: "markdown", Combine: true, Stdout: true, OutputFile: "api.md"},
+		{Format: "markdown", Combine: true, Stdout: true, OutSubdir: "docs"},
+	} {
+		if err := o.generate(examplePlugin(t, "")); err == nil {
+			t.Errorf("generate with %+v succeeded", *o)
+		}
+	}
+}
+
+func TestCombinedPackages(t *testing.T) {
+	content := generateExamples(t, GenOpts{Format: "markdown", Combine: true})["api.md"]
+	toc := "\n- com.example\n  - [example1/vehicle.proto](#example1_vehicle-proto)\n    - [Manufacturer](#com-example-Manufacturer)\n"
+	if !strings.Contains(content, toc) {
+		t.Errorf("api.md does not contain %q:\n%s", toc, content)
+	}
+	var sections []string
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "## example1/") {
+			sections = append(sections, strings.TrimPrefix(line, "## "))
+		}
+	}
+	want := []string{
+		"example1/vehicle.proto", "example1/accounts.proto", "example1/booking.proto", "example1/catalog.proto", "example1/catalog_search.proto", "example1/deprecated.proto", "example1/escaping.proto",
+		"example1/exclude.proto", "example1/groups.proto", "example1/imports.proto", "example1/maps.proto", "example1/nested.proto", "example1/options.proto", "example1/presence.proto",
+		"example1/field_presence.proto", "example1/recursive.proto", "example1/reserved.proto", "example1/rest.proto", "example1/validation.proto",
+	}
+	if !reflect.DeepEqual(sections, want) {
+		t.Errorf("sections = %q, want %q", sections, want)
+	}
+}
+
+func TestGroupByPackage(t *testing.T) {
+	content := generateExamples(t, GenOpts{Format: "markdown", Combine: true, GroupBy: groupPackage})["api.md"]
+	for _, want := range []string{
+		"\n- [com.example](#com-example)\n  - [Manufacturer](#com-example-Manufacturer)\n",
+		"\n  - [com.example.catalog](#com-example-catalog)\n    - [CatalogService](#com-example-catalog-CatalogService)\n" +
+			"    - [ListModelsRequest](#com-example-catalog-ListModelsRequest)\n    - [ListModelsResponse](#com-example-catalog-ListModelsResponse)\n" +
+			"    - [ModelQuery](#com-example-catalog-ModelQuery)\n    - [Order](#com-example-catalog-Order)\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("api.md does not contain %q:\n%s", want, content)
+		}
+	}
+	var chapters []string
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, "## ") && line != "## Table of Contents" && line != "## Scalar Value Types" {
+			chapters = append(chapters, strings.TrimPrefix(line, "## "))
+		}
+	}
+	if len(chapters) != 18 || chapters[0] != "com.example" || chapters[3] != "com.example.catalog" {
+		t.Errorf("chapters = %q, want a chapter per package", chapters)
+	}
+	// The messages of a package follow its services, whichever file
+	// declares them, and precede its enums.
+	catalog := content[strings.Index(content, "## com.example.catalog"):]
+	catalog = catalog[:strings.Index(catalog, "## com.example.deprecated")]
+	var order []int
+	for _, heading := range []string{"### CatalogService", "### ListModelsRequest", "### ModelQuery", "### Order"} {
+		order = append(order, strings.Index(catalog, heading))
+	}
+	if !sort.IntsAreSorted(order) || order[0] < 0 {
+		t.Errorf("com.example.catalog chapter is not ordered services, messages, enums:\n%s", catalog)
+	}
+
+	files := generateExamples(t, GenOpts{Format: "markdown", GroupBy: groupPackage})
+	got, ok := files["example1/com.example.catalog.md"]
+	if !ok {
+		t.Fatalf("example1/com.example.catalog.md was not generated, got %d documents", len(files))
+	}
+	if want := "[Manufacturer](com.example.md#com-example-Manufacturer)"; !strings.Contains(got, want) {
+		t.Errorf("example1/com.example.catalog.md does not contain %q:\n%s", want, got)
+	}
+	if len(files) != 18 {
+		t.Errorf("generated %d documents, want one per package", len(files))
+	}
+
+	html := generateExamples(t, GenOpts{Format: "html", Combine: true, GroupBy: groupPackage})["api.html"]
+	for _, want := range []string{`<section id="com-example-catalog">`, `<li style="margin-left: 1em"><a href="#com-example-catalog">com.example.catalog</a>`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("api.html does not contain %q", want)
+		}
+	}
+
+	for _, o := range []*GenOpts{
+		{Format: "markdown", GroupBy: "directory"},
+		{Format: "markdown", GroupBy: groupPackage, Split: splitService},
+	} {
+		if err := o.generate(examplePlugin(t, "")); err == nil {
+			t.Errorf("generate with group_by=%s and split=%s succeeded", o.GroupBy, o.Split)
+		}
+	}
+}
+
+func TestSplitService(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown", Split: splitService})
+	for name, want := range map[string][]string{
+		// accounts.proto comes first, so its AccountService keeps the name.
+		"example1/AccountService.md":   {"### AccountService", "### CloseAccountRequest", "### CloseAccountResponse", "### Reason"},
+		"example1/AccountService-2.md": {"Service for managing accounts.", "### Account\n"},
+		"example1/accounts.types.md":   {"### AuditEntry"},
+		"example1/imports.types.md":    {"[Booking](BookingService.md#com-example-booking-Booking)"},
+		"example1/vehicle.types.md":    {"### Vehicle", "### Manufacturer"},
+		// Order and State are shared by both services of the file.
+		"example1/deprecated.types.md": {"### Order", "### State", "### LegacyOrder"},
+		"example1/OrderService.md":     {"[Order](deprecated.types.md#com-example-deprecated-Order)"},
+	} {
+		content, ok := files[name]
+		if !ok {
+			t.Errorf("%s was not generated", name)
+			continue
+		}
+		for _, w := range want {
+			if !strings.Contains(content, w) {
+				t.Errorf("%s does not contain %q:\n%s", name, w, content)
+			}
+		}
+	}
+	if content := files["example1/AccountService.md"]; strings.Contains(content, "AuditEntry") {
+		t.Errorf("example1/AccountService.md documents the unreferenced AuditEntry:\n%s", content)
+	}
+	for _, name := range []string{"example1/OrderService.md", "example1/LegacyOrderService.md"} {
+		if content := files[name]; strings.Contains(content, "### Order\n") {
+			t.Errorf("%s documents the shared Order:\n%s", name, content)
+		}
+	}
+	for _, name := range []string{"example1/accounts.md", "example1/rest.types.md"} {
+		if _, ok := files[name]; ok {
+			t.Errorf("%s was generated", name)
+		}
+	}
+
+	gen := examplePlugin(t, "")
+	o := &GenOpts{Format: "markdown", Split: splitService, Combine: true}
+	if err := o.generate(gen); err == nil {
+		t.Error("generate with split=service and combine succeeded")
+	}
+}
+
+func TestSplitPage(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "html", Split: splitPage})
+	for name, want := range map[string][]string{
+		"index.html": {
+			`<a href="example1/com.example.booking.BookingService.html#com-example-booking-BookingService">BookingService</a>`,
+			`<a href="example1/com.example.nested.Outer.html#com-example-nested-Outer">Outer</a>`,
+		},
+		"example1/com.example.booking.BookingService.html": {
+			`<a href="../index.html">API Reference</a>`,
+			`<a href="com.example.booking.Booking.html#com-example-booking-Booking">Booking</a>`,
+		},
+		"example1/com.example.imports.Reservation.html": {
+			`<a href="com.example.booking.Booking.html#com-example-booking-Booking">Booking</a>`,
+		},
+		// Nested messages are documented on the page of their parent.
+		"example1/com.example.nested.Outer.html": {
+			`<section id="com-example-nested-Outer-Middle">`,
+		},
+	} {
+		content, ok := files[name]
+		if !ok {
+			t.Errorf("%s was not generated", name)
+			continue
+		}
+		for _, w := range want {
+			if !strings.Contains(content, w) {
+				t.Errorf("%s does not contain %q:\n%s", name, w, content)
+			}
+		}
+	}
+	if _, ok := files["example1/com.example.nested.Outer.Middle.html"]; ok {
+		t.Error("nested message com.example.nested.Outer.Middle got a page of its own")
+	}
+	for _, format := range []string{"markdown", "json"} {
+		o := &GenOpts{Format: format, Split: splitPage}
+		if err := o.generate(examplePlugin(t, "")); err == nil {
+			t.Errorf("generate with format %s and split=page succeeded", format)
+		}
+	}
+}
+
+func TestMkdocsNav(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown", MkdocsNav: "example1/nav.yml"})
+	nav, ok := files["example1/nav.yml"]
+	if !ok {
+		t.Fatal("example1/nav.yml was not generated")
+	}
+	for _, want := range []string{
+		"nav:\n",
+		"  - com.example.booking:\n      - example1/booking.proto: booking.md\n",
+		"  - com.example:\n      - example1/vehicle.proto: vehicle.md\n",
+	} {
+		if !strings.Contains(nav, want) {
+			t.Errorf("nav does not contain %q:\n%s", want, nav)
+		}
+	}
+	// The nav file is placed below out-subdir like the pages it lists.
+	files = generateExamples(t, GenOpts{Format: "markdown", OutSubdir: "docs", MkdocsNav: "nav.yml"})
+	want := "  - com.example.booking:\n      - example1/booking.proto: example1/booking.md\n"
+	if nav := files["docs/nav.yml"]; !strings.Contains(nav, want) {
+		t.Errorf("docs/nav.yml does not contain %q, got files %v:\n%s", want, reflect.ValueOf(files).MapKeys(), nav)
+	}
+	t.Run("combine", func(t *testing.T) {
+		gen := examplePlugin(t, "paths=source_relative")
+		opts := GenOpts{Format: "markdown", Combine: true, MkdocsNav: "nav.yml"}
+		if err := opts.generate(gen); err == nil {
+			t.Error("expected an error when combining files")
+		}
+	})
+}
+
+func TestIndex(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown", Index: "README.md"})
+	index, ok := files["README.md"]
+	if !ok {
+		t.Fatal("README.md was not generated")
+	}
+	for _, want := range []string{
+		"# API Reference\n",
+		"## com.example.booking\n\n- [example1/booking.proto](example1/booking.md): Booking related messages.\n",
+		"## com.example\n\n- [example1/vehicle.proto](example1/vehicle.md): Messages describing manufacturers / vehicles.\n",
+	} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index does not contain %q:\n%s", want, index)
+		}
+	}
+
+	t.Run("template", func(t *testing.T) {
+		dir := t.TempDir()
+		custom := `{{define "index"}}{{range .Packages}}{{range .Docs}}{{.Link}}{{"\n"}}{{end}}{{end}}{{end}}`
+		if err := os.WriteFile(filepath.Join(dir, "index.txt.tmpl"), []byte(custom), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		files := generateExamples(t, GenOpts{Format: "markdown", Index: "docs/index.txt", TemplateDirs: []string{dir}})
+		if got, want := strings.Split(files["docs/index.txt"], "\n")[0], "../example1/accounts.md"; got != want {
+			t.Errorf("first line of docs/index.txt is %q, want %q", got, want)
+		}
+	})
+	t.Run("combine", func(t *testing.T) {
+		opts := GenOpts{Format: "markdown", Combine: true, Index: "README.md"}
+		if err := opts.generate(examplePlugin(t, "")); err == nil {
+			t.Error("expected an error when combining files")
+		}
+	})
+}
+
+func TestOutputFile(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown", OutputFile: "{{.Dir}}/{{.Package}}/{{.Base}}.{{.Ext}}"})
+	if _, ok := files["example1/com.example.booking/booking.md"]; !ok {
+		t.Errorf("example1/com.example.booking/booking.md was not generated, got %v", reflect.ValueOf(files).MapKeys())
+	}
+	files = generateExamples(t, GenOpts{Format: "markdown", Combine: true, OutputFile: "README.md"})
+	if _, ok := files["README.md"]; !ok || len(files) != 1 {
+		t.Errorf("expected only README.md to be generated, got %v", reflect.ValueOf(files).MapKeys())
+	}
+	for _, outputFile := range []string{"README.md", "{{.Base"} {
+		gen := examplePlugin(t, "paths=source_relative")
+		opts := GenOpts{Format: "markdown", OutputFile: outputFile}
+		if err := opts.generate(gen); err == nil {
+			t.Errorf("expected an error for output file %q", outputFile)
+		}
+	}
+}
+
+func TestExt(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown", Ext: ".markdown"})
+	if _, ok := files["example1/booking.markdown"]; !ok {
+		t.Errorf("example1/booking.markdown was not generated, got %v", reflect.ValueOf(files).MapKeys())
+	}
+	want := "[Booking](./booking.markdown#com-example-booking-Booking)"
+	if got := files["example1/imports.markdown"]; !strings.Contains(got, want) {
+		t.Errorf("example1/imports.markdown does not contain %q:\n%s", want, got)
+	}
+	// Formats whose names aren't extensions get one of their own, and the
+	// others keep their name.
+	for format, want := range map[string]string{
+		"markdown": "md", "asciidoc": "adoc", "rst": "rst", "textile": "textile", "html": "html", "csv": "csv", "dot": "dot",
+		"openapi": "openapi.yaml", "openapi-json": "openapi.json", "postman": "postman_collection.json",
+		"plantuml": "puml", "mermaid": "mmd", "man": "7", "latex": "tex", "confluence": "wiki", "dokuwiki": "txt",
+	} {
+		if got := (&GenOpts{Format: format}).fileSuffix(); got != want {
+			t.Errorf("fileSuffix() of format %s = %q, want %q", format, got, want)
+		}
+	}
+	opts := GenOpts{Format: "markdown", Ext: "md/x"}
+	if err := opts.generate(examplePlugin(t, "")); err == nil {
+		t.Errorf("expected an error for ext %q", opts.Ext)
+	}
+
+	// The format, not the extension, decides whether comments are
+	// HTML-escaped.
+	for _, tt := range []struct {
+		opts      GenOpts
+		name      string
+		want, not string
+	}{
+		{GenOpts{Format: "html", Ext: "htm"}, "example1/escaping.htm", `&#34;&lt;id&gt; &amp; &lt;name&gt;&#34;`, `"<id> & <name>"`},
+		{GenOpts{Format: "markdown", Ext: "html"}, "example1/escaping.html", `"\<id> & \<name>"`, "&lt;"},
+	} {
+		got := generateExamples(t, tt.opts)[tt.name]
+		if !strings.Contains(got, tt.want) || strings.Contains(got, tt.not) {
+			t.Errorf("%s with ext %s does not contain %q or contains %q:\n%s", tt.name, tt.opts.Ext, tt.want, tt.not, got)
+		}
+	}
+}
+
+func TestOutSubdir(t *testing.T) {
+	tests := []struct {
+		param string
+		opts  GenOpts
+		want  string
+	}{
+		{"paths=source_relative", GenOpts{OutSubdir: "docs"}, "docs/example1/booking.md"},
+		{"paths=source_relative", GenOpts{OutSubdir: "docs/api/", TrimPrefix: "example1/"}, "docs/api/booking.md"},
+		// Without source_relative paths, files are placed by their go_package.
+		{"", GenOpts{OutSubdir: "docs"}, "docs/example.com/booking/booking.md"},
+		{"", GenOpts{OutSubdir: "./docs", OutputFile: "{{.Package}}/{{.Base}}.{{.Ext}}"}, "docs/com.example.booking/booking.md"},
+		{"", GenOpts{OutSubdir: "docs", Combine: true}, "docs/api.md"},
+		{"", GenOpts{OutSubdir: "docs", Split: splitService}, "docs/example.com/booking/BookingService.md"},
+	}
+	for _, tt := range tests {
+		gen := examplePlugin(t, tt.param)
+		tt.opts.Format = "markdown"
+		if err := tt.opts.generate(gen); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range gen.Response().File {
+			names = append(names, f.GetName())
+		}
+		found := false
+		for _, name := range names {
+			found = found || name == tt.want
+		}
+		if !found {
+			t.Errorf("%s with %q and %+v was not generated, got %q", tt.want, tt.param, tt.opts, names)
+		}
+	}
+	for _, dir := range []string{"/tmp/docs", "..", "docs/../../docs"} {
+		opts := GenOpts{Format: "markdown", OutSubdir: dir}
+		if err := opts.generate(examplePlugin(t, "")); err == nil {
+			t.Errorf("expected an error for out-subdir %q", dir)
+		}
+	}
+}
+
+func TestFlat(t *testing.T) {
+	// Without source_relative paths, files are placed by their go_package.
+	gen := examplePlugin(t, "")
+	o := &GenOpts{Format: "markdown", Flat: true, OutSubdir: "docs"}
+	if err := o.generate(gen); err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range gen.Response().File {
+		files[f.GetName()] = f.GetContent()
+	}
+	content, ok := files["docs/example.com.imports.imports.md"]
+	if !ok {
+		t.Fatalf("docs/example.com.imports.imports.md was not generated, got %v", files)
+	}
+	if _, ok := files["docs/example.com.booking.booking.md"]; !ok {
+		t.Errorf("docs/example.com.booking.booking.md was not generated")
+	}
+	if want := "[Booking](example.com.booking.booking.md#com-example-booking-Booking)"; !strings.Contains(content, want) {
+		t.Errorf("imports document does not link to %s:\n%s", want, content)
+	}
+
+	t.Run("collision", func(t *testing.T) {
+		o := &GenOpts{
+			Format:     "markdown",
+			Flat:       true,
+			OutputFile: `{{if eq .Base "booking"}}a/b{{else if eq .Base "maps"}}a.b{{else}}{{.Base}}{{end}}.md`,
+		}
+		err := o.generate(examplePlugin(t, ""))
+		if err == nil || !strings.Contains(err.Error(), "a.b.md") {
+			t.Errorf("got error %v, want a collision on a.b.md", err)
+		}
+	})
+}
+
+func TestFieldLayout(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown", FieldLayout: "list"})
+	content := files["example1/vehicle.md"]
+	want := "**id**<br>\nrequired [int32](#int32), number 1, JSON name `id`\n\nThe unique manufacturer ID.\n"
+	if !strings.Contains(content, want) {
+		t.Errorf("example1/vehicle.md does not contain %q:\n%s", want, content)
+	}
+	if strings.Contains(content, "| Field |") {
+		t.Errorf("example1/vehicle.md has a field table:\n%s", content)
+	}
+
+	gen := examplePlugin(t, "paths=source_relative")
+	opts := GenOpts{Format: "markdown", FieldLayout: "grid"}
+	if err := opts.generate(gen); err == nil {
+		t.Error("expected an error for field_layout=grid")
+	}
+}
+
+func TestHTMLStandalone(t *testing.T) {
+	link := `<a href="./booking.html#com-example-booking-Booking">Booking</a>`
+	files := generateExamples(t, GenOpts{Format: "html"})
+	if got := files["example1/imports.html"]; !strings.Contains(got, link) {
+		t.Errorf("example1/imports.html does not contain %q:\n%s", link, got)
+	}
+	files = generateExamples(t, GenOpts{Format: "html", HTMLStandalone: true})
+	got := files["example1/imports.html"]
+	if strings.Contains(got, link) || !strings.Contains(got, "<td>Booking</td>") {
+		t.Errorf("example1/imports.html links to booking.html:\n%s", got)
+	}
+	if want := ".sidebar { position: fixed;"; !strings.Contains(got, want) {
+		t.Errorf("example1/imports.html does not inline the default stylesheet:\n%s", got)
+	}
+
+	css := filepath.Join(t.TempDir(), "custom.css")
+	if err := os.WriteFile(css, []byte("body { color: red; }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files = generateExamples(t, GenOpts{Format: "html", HTMLStandalone: true, CSS: css})
+	want := "<style>\nbody { color: red; }\n</style>"
+	if got := files["example1/imports.html"]; !strings.Contains(got, want) {
+		t.Errorf("example1/imports.html does not contain %q:\n%s", want, got)
+	}
+
+	gen := examplePlugin(t, "paths=source_relative")
+	opts := GenOpts{Format: "html", CSS: filepath.Join(t.TempDir(), "missing.css")}
+	if err := opts.generate(gen); err == nil {
+		t.Error("expected an error for a missing stylesheet")
+	}
+}
+
+func TestTemplateDirs(t *testing.T) {
+	team, shared := t.TempDir(), t.TempDir()
+	for name, content := range map[string]string{
+		filepath.Join(team, "markdown.tmpl"):             `{{define "output"}}{{ .Desc.Package }}{{ template "header" }}{{ template "footer" }}{{end}}`,
+		filepath.Join(team, "partials", "header.tmpl"):   `{{define "header"}} team header{{end}}`,
+		filepath.Join(shared, "markdown.tmpl"):           `{{define "output"}}shadowed{{end}}`,
+		filepath.Join(shared, "partials", "footer.tmpl"): `{{define "footer"}} shared footer{{end}}`,
+		filepath.Join(shared, "partials", "header.tmpl"): `{{define "header"}} shared header{{end}}`,
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := generateExamples(t, GenOpts{Format: "markdown", TemplateDirs: []string{team, shared}})
+	want := "com.example.booking team header shared footer"
+	if got := files["example1/booking.md"]; got != want {
+		t.Errorf("example1/booking.md = %q, want %q", got, want)
+	}
+
+	// Formats the directories don't override fall back to the embedded
+	// templates.
+	files = generateExamples(t, GenOpts{Format: "asciidoc", TemplateDirs: []string{team, shared}})
+	if got := files["example1/booking.adoc"]; !strings.HasPrefix(got, "= com.example.booking\n") {
+		t.Errorf("example1/booking.adoc was not rendered with the embedded template:\n%s", got)
+	}
+}
+
+func TestTemplateDirPaths(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "custom.tmpl"), []byte(`{{define "output"}}{{ .Desc.Package }}{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{dir, rel} {
+		files := generateExamples(t, GenOpts{Format: "custom", TemplateDirs: []string{d}})
+		if got := files["example1/booking.custom"]; got != "com.example.booking" {
+			t.Errorf("templates=%s: example1/booking.custom = %q", d, got)
+		}
+	}
+
+	o := &GenOpts{Format: "missing", TemplateDirs: []string{rel}}
+	err = o.generate(examplePlugin(t, ""))
+	if err == nil || !strings.Contains(err.Error(), "missing.tmpl") || !strings.Contains(err.Error(), "custom.tmpl") {
+		t.Errorf("generate with a missing template = %v, want an error listing custom.tmpl", err)
+	}
+	o = &GenOpts{Format: "markdown", TemplateDirs: []string{filepath.Join(dir, "missing")}}
+	if err := o.generate(examplePlugin(t, "")); err == nil {
+		t.Error("generate with a missing templates directory succeeded")
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "markdown"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "markdown", "bad.tmpl")
+	if err := os.WriteFile(bad, []byte(`{{define "field_type"}}{{ .Nope }}{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	o := &GenOpts{Format: "markdown", TemplateDirs: []string{dir}}
+	err := o.generate(examplePlugin(t, ""))
+	if err == nil {
+		t.Fatal("generate with a failing template succeeded")
+	}
+	for _, want := range []string{`format "markdown": ` + bad + `:1:`, `executing "field_type" at <.Nope>`, "(rendering field com.example."} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	if err := os.WriteFile(bad, []byte(`{{define "field_type"}}{{ if }}{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = o.generate(examplePlugin(t, ""))
+	if err == nil {
+		t.Fatal("generate with a template failing to parse succeeded")
+	}
+	for _, want := range []string{bad + ": ", "embedded templates/markdown.tmpl", "embedded templates/partials/http.tmpl"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	// Errors of template functions can still be told apart.
+	o = &GenOpts{TemplateFile: filepath.Join(dir, "include.md.tmpl")}
+	if err := os.WriteFile(o.TemplateFile, []byte(`{{define "output"}}{{ include_file "missing.md" }}{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	o.IncludeDir = dir
+	if err := o.generate(examplePlugin(t, "")); !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), o.TemplateFile+":1:") {
+		t.Errorf("generate with a missing include = %v, want fs.ErrNotExist naming %s", err, o.TemplateFile)
+	}
+}
+
+func TestTemplateFile(t *testing.T) {
+	dir := t.TempDir()
+	md := filepath.Join(dir, "api.md.tmpl")
+	if err := os.WriteFile(md, []byte(`{{define "output"}}{{ range .Services }}{{ range .Methods }}{{ template "http_rules" (http_rules .) }};{{ end }}{{ end }}{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	html := filepath.Join(dir, "page.html.tpl")
+	if err := os.WriteFile(html, []byte(`{{define "output"}}<p>{{ (index .Messages 0).Desc.Name }} & {{ "<b>" }}</p>{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file replaces the format, even one generated in Go code.
+	files := generateExamples(t, GenOpts{Format: "json", TemplateFile: md})
+	want := "`GET /v1/shelves/{id}`, `GET /v1/libraries/{library}/shelves/{id}`;"
+	if got := files["example1/rest.md"]; !strings.HasPrefix(got, want) {
+		t.Errorf("example1/rest.md = %q, want it to start with %q", got, want)
+	}
+	if _, ok := files["example1/rest.json"]; ok {
+		t.Error("example1/rest.json was generated")
+	}
+
+	files = generateExamples(t, GenOpts{TemplateFile: html})
+	if got, want := files["example1/booking.html"], "<p>BookingStatusID & &lt;b&gt;</p>"; got != want {
+		t.Errorf("example1/booking.html = %q, want %q", got, want)
+	}
+
+	o := &GenOpts{TemplateFile: md, TemplateDirs: []string{dir}}
+	if err := o.generate(examplePlugin(t, "")); err == nil || !strings.Contains(err.Error(), "template_file") {
+		t.Errorf("generate with template_file and templates = %v, want an error", err)
+	}
+	o = &GenOpts{TemplateFile: filepath.Join(dir, "missing.md.tmpl")}
+	if err := o.generate(examplePlugin(t, "")); err == nil {
+		t.Error("generate with a missing template_file succeeded")
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	contains := func(names []string, name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	full := (&GenOpts{Format: "markdown"}).funcNames()
+	minimal := (&GenOpts{Format: "markdown", Funcs: funcsMinimal}).funcNames()
+	for _, name := range []string{"field_anchor", "md_escape", "upper", "default"} {
+		if !contains(full, name) || !contains(minimal, name) {
+			t.Errorf("%s is not available to templates", name)
+		}
+	}
+	for _, name := range unsafeSprigFuncs {
+		if !contains(full, name) {
+			t.Errorf("%s is not available with funcs=full", name)
+		}
+		if contains(minimal, name) {
+			t.Errorf("%s is available with funcs=minimal", name)
+		}
+	}
+
+	tmpl := filepath.Join(t.TempDir(), "env.md.tmpl")
+	if err := os.WriteFile(tmpl, []byte(`{{define "output"}}{{ env "HOME" }}{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	o := &GenOpts{TemplateFile: tmpl, Funcs: funcsMinimal}
+	if err := o.generate(examplePlugin(t, "")); err == nil || !strings.Contains(err.Error(), `"env" not defined`) {
+		t.Errorf("generate with env and funcs=minimal = %v, want an error", err)
+	}
+	o = &GenOpts{Funcs: "none"}
+	if err := o.generate(examplePlugin(t, "")); err == nil {
+		t.Error("generate with an invalid funcs succeeded")
+	}
+}
+
+func TestIncludeFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "guides"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "guides", "start.md"), []byte("Getting started.\n\nCall the API.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	o := &GenOpts{IncludeDir: dir}
+	for _, tt := range []struct {
+		name   string
+		indent []int
+		want   string
+	}{
+		{"guides/start.md", nil, "Getting started.\n\nCall the API.\n"},
+		{"guides/../guides/start.md", nil, "Getting started.\n\nCall the API.\n"},
+		{"guides/start.md", []int{3}, "   Getting started.\n\n   Call the API.\n"},
+	} {
+		got, err := o.includeFile(tt.name, tt.indent...)
+		if err != nil {
+			t.Errorf("includeFile(%q, %v) failed: %v", tt.name, tt.indent, err)
+		} else if got != tt.want {
+			t.Errorf("includeFile(%q, %v) = %q, want %q", tt.name, tt.indent, got, tt.want)
+		}
+	}
+	for _, name := range []string{"/etc/passwd", "../start.md", "guides/../../start.md", ""} {
+		if _, err := o.includeFile(name); err == nil {
+			t.Errorf("includeFile(%q) succeeded", name)
+		}
+	}
+	if _, err := o.includeFile("guides/missing.md"); err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "guides", "missing.md")) {
+		t.Errorf("includeFile of a missing file = %v, want an error naming its path", err)
+	}
+	if _, err := (&GenOpts{}).includeFile("guides/start.md"); err == nil {
+		t.Error("includeFile without include_dir succeeded")
+	}
+
+	tmpl := filepath.Join(t.TempDir(), "api.adoc.tmpl")
+	if err := os.WriteFile(tmpl, []byte(`{{define "output"}}----
+{{ include_file "guides/start.md" 2 }}----
+{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files := generateExamples(t, GenOpts{Format: "asciidoc", TemplateFile: tmpl, IncludeDir: dir})
+	if got, want := files["example1/booking.adoc"], "----\n  Getting started.\n\n  Call the API.\n----\n"; got != want {
+		t.Errorf("example1/booking.adoc = %q, want %q", got, want)
+	}
+}
+
+func TestDelims(t *testing.T) {
+	dir := t.TempDir()
+	// The embedded partials keep the default delimiters.
+	text := `[[define "output"]]{{< note >}}[[ .Desc.Package ]][[ range .Services ]][[ range .Methods ]] [[ template "http_rules" (http_rules .) ]][[ end ]][[ end ]]{{< /note >}}[[end]]`
+	if err := os.WriteFile(filepath.Join(dir, "hugo.tmpl"), []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files := generateExamples(t, GenOpts{Format: "hugo", Ext: "md", TemplateDirs: []string{dir}, LeftDelim: "[[", RightDelim: "]]"})
+	want := "{{< note >}}com.example.rest `GET /v1/shelves/{id}`, `GET /v1/libraries/{library}/shelves/{id}`"
+	if got := files["example1/rest.md"]; !strings.HasPrefix(got, want) {
+		t.Errorf("example1/rest.md = %q, want it to start with %q", got, want)
+	}
+	files = generateExamples(t, GenOpts{TemplateFile: filepath.Join(dir, "hugo.tmpl"), Ext: "md", LeftDelim: "[[", RightDelim: "]]"})
+	if got := files["example1/rest.md"]; !strings.HasPrefix(got, want) {
+		t.Errorf("example1/rest.md with template_file = %q, want it to start with %q", got, want)
+	}
+
+	var f delimsFlag
+	for in, want := range map[string]delimsFlag{
+		"[[ ]]":       {"[[", "]]"},
+		"<%25%2C%25>": {"<%", "%>"},
+	} {
+		if err := f.Set(in); err != nil || f != want {
+			t.Errorf("Set(%q) = %q, %v, want %q", in, f, err, want)
+		}
+	}
+	if err := f.Set("[["); err == nil {
+		t.Error("Set with a single delimiter succeeded")
+	}
+	for _, delims := range [][2]string{{"[[", "[["}, {"", "]]"}} {
+		o := &GenOpts{Format: "markdown", LeftDelim: delims[0], RightDelim: delims[1]}
+		if err := o.generate(examplePlugin(t, "")); err == nil {
+			t.Errorf("generate with delims %q succeeded", delims)
+		}
+	}
+}
+
+func TestTemplatePartials(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("markdown.tmpl", `{{define "output"}}{{ .Desc.Package }}{{ template "heading" }}{{ template "field_table" }}{{end}}`)
+	write("partials/heading.tmpl", `{{define "heading"}} shared heading{{end}}`)
+	write("markdown/heading.tmpl", `{{define "heading"}} markdown heading{{end}}`)
+	write("markdown/field_table.tmpl", `{{define "field_table"}} fields{{end}}`)
+	write("asciidoc/heading.tmpl", `{{define "heading"}} asciidoc heading{{end}}`)
+
+	files := generateExamples(t, GenOpts{Format: "markdown", TemplateDirs: []string{dir}})
+	want := "com.example.booking markdown heading fields"
+	if got := files["example1/booking.md"]; got != want {
+		t.Errorf("example1/booking.md = %q, want %q", got, want)
+	}
+
+	write("markdown/broken.tmpl", `{{define "broken"}}{{ end`)
+	o := &GenOpts{Format: "markdown", TemplateDirs: []string{dir}}
+	err := o.generate(examplePlugin(t, ""))
+	if want := filepath.Join(dir, "markdown", "broken.tmpl"); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("generate with a broken partial = %v, want an error naming %s", err, want)
+	}
+}
+
+func TestTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "markdown"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// The file is named unlike the embedded one defining "enum", and sorts
+	// before markdown/package.tmpl, yet its definition wins.
+	enum := `{{define "enum"}}Enum {{ .Desc.Name }}.{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "markdown", "enums.tmpl"), []byte(enum), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got := generateExamples(t, GenOpts{Format: "markdown", TemplateDirs: []string{dir}})["example1/field_presence.md"]
+	want := generateExamples(t, GenOpts{Format: "markdown"})["example1/field_presence.md"]
+	if got != want {
+		t.Errorf("field_presence.md without enums changed:\n%s", got)
+	}
+	got = generateExamples(t, GenOpts{Format: "markdown", TemplateDirs: []string{dir}})["example1/vehicle.md"]
+	for _, want := range []string{"Enum Coolness.", "<a name=\"top\"></a>", "### Vehicle"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("vehicle.md does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "| Name | Number | Description |") {
+		t.Errorf("vehicle.md has the embedded enum table:\n%s", got)
+	}
+}
+
+func TestFrontMatter(t *testing.T) {
+	frontMatter := []string{"weight=10", "title=Bookings"}
+	files := generateExamples(t, GenOpts{Format: "markdown", FrontMatter: frontMatter})
+	want := "---\ntitle: Bookings\ndescription: API Specification for the com.example.booking package.\nproto_file: example1/booking.proto\nweight: 10\n---\n\n<a name=\"top\">"
+	if got := files["example1/booking.md"]; !strings.HasPrefix(got, want) {
+		t.Errorf("example1/booking.md does not start with %q:\n%s", want, got)
+	}
+
+	// The configured fields are merged into the front matter of mdx
+	// documents rather than written as a block of their own.
+	files = generateExamples(t, GenOpts{Format: "mdx", FrontMatter: frontMatter})
+	want = "---\nid: example1_booking-proto\ntitle: Bookings\nsidebar_label: booking\nproto_file: example1/booking.proto\nweight: 10\n---\n\nAPI Specification"
+	if got := files["example1/booking.mdx"]; !strings.HasPrefix(got, want) {
+		t.Errorf("example1/booking.mdx does not start with %q:\n%s", want, got)
+	}
+
+	// Templates that don't write front matter are given the automatic and
+	// configured fields, which they also receive as .FrontMatter.
+	dir := t.TempDir()
+	text := `{{define "output"}}# {{ index .FrontMatter "proto_file" }}{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "asciidoc.tmpl"), []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files = generateExamples(t, GenOpts{Format: "asciidoc", TemplateDirs: []string{dir}, FrontMatter: frontMatter})
+	want = "---\ntitle: Bookings\nproto_file: example1/booking.proto\nweight: 10\n---\n\n# example1/booking.proto"
+	if got := files["example1/booking.adoc"]; got != want {
+		t.Errorf("example1/booking.adoc = %q, want %q", got, want)
+	}
+	files = generateExamples(t, GenOpts{Format: "asciidoc", TemplateDirs: []string{dir}})
+	if got := files["example1/booking.adoc"]; got != "# " {
+		t.Errorf("example1/booking.adoc without front matter = %q", got)
+	}
+
+	// Formats generated in Go and HTML have no front matter.
+	files = generateExamples(t, GenOpts{Format: "json", FrontMatter: frontMatter})
+	if got := files["example1/booking.json"]; !json.Valid([]byte(got)) {
+		t.Errorf("example1/booking.json is not valid JSON:\n%s", got)
+	}
+	files = generateExamples(t, GenOpts{Format: "html", FrontMatter: frontMatter})
+	if got := files["example1/booking.html"]; strings.HasPrefix(got, "---") {
+		t.Errorf("example1/booking.html starts with front matter:\n%.200s", got)
+	}
+
+	var f frontMatterFlag
+	for _, s := range []string{"weight", "=x", "=", "title=100%"} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("frontmatter=%s succeeded", s)
+		}
+	}
+	if err := f.Set("title=Acme%2C%20Inc."); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("summary=a%3Db"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (frontMatterFlag{"title=Acme, Inc.", "summary=a=b"}); !reflect.DeepEqual(f, want) {
+		t.Errorf("frontmatter %q, want %q", f, want)
+	}
+}
+
+func TestDeprecatedBadges(t *testing.T) {
+	files := generateExamples(t, GenOpts{Format: "markdown"})
+	content := files["example1/deprecated.md"]
+	for _, want := range []string{
+		"### LegacyOrderService **Deprecated**",
+		"| GetOrder **Deprecated** |",
+		"| legacy_id **Deprecated** |",
+		"### LegacyOrder **Deprecated**",
+		"| STATE_PENDING **Deprecated** |",
+		"### LegacyState **Deprecated**",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("deprecated.md does not contain %q", want)
+		}
+	}
+	for _, notWant := range []string{
+		"### OrderService **Deprecated**",
+		"| STATE_OPEN **Deprecated** |",
+	} {
+		if strings.Contains(content, notWant) {
+			t.Errorf("deprecated.md contains %q", notWant)
+		}
+	}
+}
+
+func TestWellKnownTypes(t *testing.T) {
+	fields := make(map[string]*protogen.Field)
+	for _, f := range exampleMessage(t, "com.example.imports.Reservation").Fields {
+		fields[string(f.Desc.Name())] = f
+	}
+	o := &GenOpts{}
+	for _, tt := range []struct {
+		field, display, link string
+		wkt                  bool
+	}{
+		{"created_at", "RFC 3339 timestamp", "https://protobuf.dev/reference/protobuf/google.protobuf/#timestamp", true},
+		{"hold", "duration", "https://protobuf.dev/reference/protobuf/google.protobuf/#duration", true},
+		{"booking", "Booking", "", false},
+		{"notes", "string", "", false},
+	} {
+		f := fields[tt.field]
+		if got := o.isWKT(f); got != tt.wkt {
+			t.Errorf("isWKT(%s) = %v, want %v", tt.field, got, tt.wkt)
+		}
+		if got := o.wktDisplay(f); got != tt.display {
+			t.Errorf("wktDisplay(%s) = %q, want %q", tt.field, got, tt.display)
+		}
+		if got := wktLink(f); got != tt.link {
+			t.Errorf("wktLink(%s) = %q, want %q", tt.field, got, tt.link)
+		}
+	}
+
+	o = &GenOpts{WKT: []string{"google.protobuf.Duration=", "google.protobuf.Timestamp=instant", "com.example.booking.Booking=a booking"}}
+	if o.isWKT(fields["hold"]) {
+		t.Error("Duration is recognized after being overridden with an empty description")
+	}
+	if got := o.wktDisplay(fields["created_at"]); got != "instant" {
+		t.Errorf("overridden Timestamp display is %q, want %q", got, "instant")
+	}
+	if !o.isWKT(fields["booking"]) || wktLink(fields["booking"]) != "" {
+		t.Error("Booking is not recognized without a link after being added")
+	}
+}
+
+func TestTitleVersion(t *testing.T) {
+	opts := GenOpts{Title: "Acme API", Version: "v1.2.0", Description: "Vehicles, bookings and more."}
+	for _, tt := range []struct {
+		format, name string
+		combine      bool
+		want         []string
+	}{
+		{"markdown", "example1/booking.md", false, []string{"---\n\n# Acme API\n\nVersion v1.2.0\n\nVehicles, bookings and more.\n\n<a name=\"top\"></a>"}},
+		{"markdown", "api.md", true, []string{"title: Acme API\n", "# Acme API\n\nVersion v1.2.0\n"}},
+		{"html", "api.html", true, []string{"<title>Acme API</title>", "<h1 id=\"top\">Acme API</h1>\n<p class=\"version\">Version v1.2.0</p>\n<p class=\"description\">Vehicles, bookings and more.</p>"}},
+		{"openapi", "example1/rest.openapi.yaml", false, []string{"title: Acme API\n", "version: v1.2.0\n", "description: Vehicles, bookings and more.\n"}},
+	} {
+		o := opts
+		o.Format, o.Combine = tt.format, tt.combine
+		content, ok := generateExamples(t, o)[tt.name]
+		if !ok {
+			t.Errorf("%s was not generated", tt.name)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(content, want) {
+				t.Errorf("%s does not contain %q:\n%s", tt.name, want, content)
+			}
+		}
+	}
+
+	// Without a title, documents covering several files keep the default one.
+	content := generateExamples(t, GenOpts{Format: "markdown", Combine: true})["api.md"]
+	if !strings.HasPrefix(content, "---\ntitle: API Reference\n") || strings.Contains(content, "Version") {
+		t.Errorf("combined document without title and version starts with:\n%.200s", content)
+	}
+}
+
+func TestEscapedFlag(t *testing.T) {
+	var f escapedFlag
+	if err := f.Set("Acme%2C%20Inc. API+v2"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(f), "Acme, Inc. API+v2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if err := f.Set("100%"); err == nil {
+		t.Error("expected an error for an invalid escape")
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	var flags flag.FlagSet
+	format := flags.String("format", "markdown", "")
+	combine := flags.Bool("combine", false, "")
+	flags.BoolVar(combine, "merge", false, "")
+	split := flags.String("split", splitFile, "")
+	var title escapedFlag
+	flags.Var(&title, "title", "")
+	var includePackages patternsFlag
+	flags.Var(&includePackages, "include-package", "")
+	var frontMatter frontMatterFlag
+	flags.Var(&frontMatter, "frontmatter", "")
+	if err := flags.Set("format", "html"); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Set("merge", "false"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "apidocs.yaml")
+	config := "format: json\ncombine: true\nsplit: service\ntitle: 100% Acme, Inc.\n" +
+		"include-package: [com.acme.*, com.other]\nfrontmatter: {weight: 10, draft: false}\n"
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(&flags, path); err != nil {
+		t.Fatal(err)
+	}
+	if *format != "html" || *combine {
+		t.Errorf("explicit options were overridden: format %q, combine %v", *format, *combine)
+	}
+	if *split != splitService || title != "100% Acme, Inc." {
+		t.Errorf("split %q, title %q", *split, title)
+	}
+	if want := (patternsFlag{"com.acme.*", "com.other"}); !reflect.DeepEqual(includePackages, want) {
+		t.Errorf("include-package %q, want %q", includePackages, want)
+	}
+	if want := (frontMatterFlag{"draft=false", "weight=10"}); !reflect.DeepEqual(frontMatter, want) {
+		t.Errorf("frontmatter %q, want %q", frontMatter, want)
+	}
+
+	for _, config := range []string{"fromat: json\n", "split: [a, [b]]\n", `{"frontmatter": ["draft"]}`} {
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyConfig(&flags, path); err == nil {
+			t.Errorf("config %q was accepted", config)
+		}
+	}
+}
+
+func TestVars(t *testing.T) {
+	vars := make(varsFlag)
+	for _, s := range []string{"base_url=https%3A%2F%2Fapi.example.com", "env=staging", "env=production"} {
+		if err := vars.Set(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := (varsFlag{"base_url": "https://api.example.com", "env": "production"}); !reflect.DeepEqual(vars, want) {
+		t.Errorf("vars = %q, want %q", vars, want)
+	}
+	for _, s := range []string{"base_url", "=value", "env=100%"} {
+		if err := vars.Set(s); err == nil {
+			t.Errorf("var %q was accepted", s)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "markdown.tmpl"), []byte(`{{define "output"}}{{ .Vars.base_url }}/{{ .Desc.Package }}{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files := generateExamples(t, GenOpts{Format: "markdown", TemplateDirs: []string{dir}, Vars: vars})
+	if got, want := files["example1/booking.md"], "https://api.example.com/com.example.booking"; got != want {
+		t.Errorf("example1/booking.md = %q, want %q", got, want)
+	}
+
+	// Templates read the pairs of the template-data alias from .Extra.
+	tmpl := filepath.Join(t.TempDir(), "extra.md.tmpl")
+	if err := os.WriteFile(tmpl, []byte(`{{define "output"}}{{ .Extra.env }} {{ .Vars.env }}{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files = generateExamples(t, GenOpts{TemplateFile: tmpl, Vars: vars})
+	if got, want := files["example1/booking.md"], "production production"; got != want {
+		t.Errorf("example1/booking.md = %q, want %q", got, want)
+	}
+}
+
+func TestFooter(t *testing.T) {
+	for _, tt := range []struct {
+		footer, want string
+	}{
+		{footerGenerated, "\n---\n\nGenerated from example1/booking.proto by protoc-gen-apidocs"},
+		{"Maintained by the API team.", "\n---\n\nMaintained by the API team.\n"},
+	} {
+		content := generateExamples(t, GenOpts{Format: "markdown", Footer: tt.footer})["example1/booking.md"]
+		if !strings.Contains(content, tt.want) || !strings.HasSuffix(content, ".\n") {
+			t.Errorf("footer=%s: booking.md does not end with %q:\n%s", tt.footer, tt.want, content)
+		}
+	}
+	content := generateExamples(t, GenOpts{Format: "markdown", Footer: footerNone})["example1/booking.md"]
+	checkGolden(t, "example1/booking.md", content)
+
+	req := &pluginpb.CodeGeneratorRequest{
+		Parameter:       proto.String("footer=generated"),
+		CompilerVersion: &pluginpb.Version{Major: proto.Int32(3), Minor: proto.Int32(21), Patch: proto.Int32(12)},
+	}
+	meta := (&GenOpts{Footer: footerGenerated}).generationMeta(req)
+	if meta.CompilerVersion != "3.21.12" || meta.Parameter != "footer=generated" {
+		t.Errorf("got compiler version %q and parameter %q", meta.CompilerVersion, meta.Parameter)
+	}
+	if !strings.HasSuffix(meta.Footer, " with protoc 3.21.12.") {
+		t.Errorf("generated footer %q does not name the compiler", meta.Footer)
+	}
+
+	o := &GenOpts{Footer: footerGenerated, meta: meta}
+	fileMeta := o.fileMeta(examplePlugin(t, "").FilesByPath["example1/booking.proto"])
+	if fileMeta.Source != "example1/booking.proto" || fileMeta.Package != "com.example.booking" || fileMeta.Syntax != "proto3" {
+		t.Errorf("got source %q, package %q and syntax %q", fileMeta.Source, fileMeta.Package, fileMeta.Syntax)
+	}
+	if !strings.HasPrefix(fileMeta.Footer, "Generated from example1/booking.proto by ") {
+		t.Errorf("generated footer %q does not name the source", fileMeta.Footer)
+	}
+	if got := o.fileMeta(nil); got.Source != "" || strings.Contains(got.Footer, " from ") {
+		t.Errorf("meta of a combined document names a source: %+v", got)
+	}
+	content = generateExamples(t, GenOpts{Format: "markdown", Combine: true, Footer: footerGenerated})["api.md"]
+	if !strings.Contains(content, "\n---\n\nGenerated by protoc-gen-apidocs") {
+		t.Errorf("combined document does not end with the generated footer:\n%s", content)
+	}
+
+	defer func(v string) { buildVersion = v }(buildVersion)
+	buildVersion = "v1.2.3"
+	if got := pluginVersion(); got != "v1.2.3" {
+		t.Errorf("pluginVersion() = %q, want the build version", got)
+	}
+}
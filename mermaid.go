@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// mermaidDiagram collects the classes and relationships of a mermaid
+// classDiagram in the order they are added.
+type mermaidDiagram struct {
+	o        *GenOpts
+	classes  []string
+	declared map[protoreflect.FullName]bool
+	// local holds the files being drawn; types declared elsewhere are drawn
+	// as classes without members, labeled with their full names.
+	local map[string]bool
+	// relations holds the relationships by source and target, so that the
+	// fields referencing the same type share a single arrow.
+	relations map[[2]protoreflect.FullName]*mermaidRelation
+	order     []*mermaidRelation
+}
+
+// mermaidRelation is an arrow between two classes of a mermaid diagram.
+type mermaidRelation struct {
+	from, to protoreflect.FullName
+	// enum is set when to is an enum, which is drawn as a dependency.
+	enum bool
+	// many is set when any of the fields holds any number of values.
+	many   bool
+	fields []string
+}
+
+// mermaidClassDiagram returns a mermaid classDiagram of the messages and
+// enums of files. Messages are classes with their fields as members and
+// enums are classes of their own annotated <<enumeration>>. Fields holding
+// messages are drawn as associations and fields holding enums as
+// dependencies, with a single arrow, labeled with every field, per pair of
+// types. Classes are identified by mermaidID and labeled with their long
+// names, or their full names when declared in other files. The result is
+// empty when files declare no messages or enums.
+func (o *GenOpts) mermaidClassDiagram(files []*protogen.File) string {
+	d := &mermaidDiagram{
+		o:         o,
+		declared:  make(map[protoreflect.FullName]bool),
+		local:     make(map[string]bool),
+		relations: make(map[[2]protoreflect.FullName]*mermaidRelation),
+	}
+	for _, f := range files {
+		d.local[f.Desc.Path()] = true
+	}
+	for _, f := range files {
+		for _, msg := range f.Messages {
+			d.addMessage(msg)
+		}
+		for _, e := range f.Enums {
+			d.addEnum(e)
+		}
+	}
+	for _, f := range files {
+		for _, msg := range f.Messages {
+			d.addRelations(msg)
+		}
+	}
+	if len(d.classes) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("classDiagram\n")
+	for _, c := range d.classes {
+		b.WriteString(c)
+	}
+	for _, r := range d.order {
+		arrow := "-->"
+		if r.enum {
+			arrow = "..>"
+		}
+		if r.many {
+			arrow += ` "*"`
+		}
+		fmt.Fprintf(&b, "  %s %s %s : %s\n", mermaidID(r.from), arrow, mermaidID(r.to), strings.Join(r.fields, ", "))
+	}
+	return b.String()
+}
+
+// mermaidDiagramFunc implements the mermaid_diagram template function,
+// returning the class diagram of the files of a document, of a file, of the
+// files of a package with group_by=package, or of a list of files such as
+// .Files.
+func (o *GenOpts) mermaidDiagramFunc(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case *TemplateData:
+		return o.mermaidClassDiagram(v.Files), nil
+	case *protogen.File:
+		return o.mermaidClassDiagram([]*protogen.File{v}), nil
+	case *PackageFiles:
+		return o.mermaidClassDiagram(v.Files), nil
+	case []*protogen.File:
+		return o.mermaidClassDiagram(v), nil
+	}
+	return "", fmt.Errorf("mermaid_diagram of %T, want a document, a file, a package or a list of files", v)
+}
+
+// renderMermaid writes the class diagram of the document for the mermaid
+// format, e.g. for `mmdc -i booking.mmd`.
+func (o *GenOpts) renderMermaid(data *TemplateData, w io.Writer) error {
+	diagram := o.mermaidClassDiagram(data.Files)
+	if diagram == "" {
+		diagram = "classDiagram\n"
+	}
+	_, err := io.WriteString(w, diagram)
+	return err
+}
+
+// addMessage adds the class of msg and those of the messages and enums
+// nested in it. Map entries are left out, map fields are members of their
+// own instead.
+func (d *mermaidDiagram) addMessage(msg *protogen.Message) {
+	var members []string
+	for _, f := range msg.Fields {
+		members = append(members, d.memberType(f)+" "+string(f.Desc.Name()))
+	}
+	d.class(msg.Desc, members)
+	for _, nested := range nestedMessages(msg) {
+		d.addMessage(nested)
+	}
+	for _, e := range msg.Enums {
+		d.addEnum(e)
+	}
+}
+
+func (d *mermaidDiagram) addEnum(e *protogen.Enum) {
+	members := []string{"<<enumeration>>"}
+	for _, v := range enumValues(e) {
+		members = append(members, string(v.Desc.Name()))
+	}
+	d.class(e.Desc, members)
+}
+
+// memberType returns the type of f as a member of a class. Mermaid writes
+// generic types between tildes, so maps read e.g. "map~string,Label~".
+func (d *mermaidDiagram) memberType(f *protogen.Field) string {
+	if f.Desc.IsMap() {
+		return fmt.Sprintf("map~%s,%s~", d.o.fieldType(f.Message.Fields[0]), d.o.fieldType(f.Message.Fields[1]))
+	}
+	typ := d.o.fieldType(f)
+	if f.Desc.IsList() {
+		typ += "[]"
+	}
+	return typ
+}
+
+// addRelations adds the relationships of the fields of msg, and of the
+// messages nested in it, to the messages and enums they hold.
+func (d *mermaidDiagram) addRelations(msg *protogen.Message) {
+	for _, ref := range messageRefs(msg) {
+		key := [2]protoreflect.FullName{msg.Desc.FullName(), ref.Target}
+		r, ok := d.relations[key]
+		if !ok {
+			r = &mermaidRelation{from: key[0], to: key[1], enum: ref.Enum}
+			d.relations[key] = r
+			d.order = append(d.order, r)
+			d.imported(ref)
+		}
+		r.many = r.many || ref.Many
+		r.fields = append(r.fields, string(ref.Field.Desc.Name()))
+	}
+	for _, nested := range nestedMessages(msg) {
+		d.addRelations(nested)
+	}
+}
+
+// imported declares the target of ref when it is declared in another file.
+func (d *mermaidDiagram) imported(ref messageRef) {
+	typ := ref.Field
+	if typ.Desc.IsMap() {
+		typ = typ.Message.Fields[1]
+	}
+	if typ.Message != nil {
+		d.class(typ.Message.Desc, nil)
+	} else {
+		d.class(typ.Enum.Desc, nil)
+	}
+}
+
+// class declares desc with members, once. Declarations of files that aren't
+// drawn are declared without members.
+func (d *mermaidDiagram) class(desc protoreflect.Descriptor, members []string) {
+	if d.declared[desc.FullName()] {
+		return
+	}
+	d.declared[desc.FullName()] = true
+	label := longName(desc)
+	if !d.local[desc.ParentFile().Path()] {
+		label, members = string(desc.FullName()), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "  class %s[\"%s\"]", mermaidID(desc.FullName()), label)
+	if len(members) > 0 {
+		b.WriteString(" {\n")
+		for _, m := range members {
+			fmt.Fprintf(&b, "    %s\n", m)
+		}
+		b.WriteString("  }")
+	}
+	b.WriteString("\n")
+	d.classes = append(d.classes, b.String())
+}
+
+// mermaidID returns the identifier a mermaid diagram declares the message
+// or enum named name as. Mermaid identifiers cannot contain dots.
+func mermaidID(name protoreflect.FullName) string {
+	return strings.ReplaceAll(string(name), ".", "_")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// pluginName is the name the plugin is invoked by.
+const pluginName = "protoc-gen-apidocs"
+
+// buildVersion is the version of the plugin set when building it, e.g. with
+// go build -ldflags "-X main.buildVersion=v1.2.0". It takes precedence over
+// the module version, see pluginVersion.
+var buildVersion string
+
+// Footers, see GenOpts.Footer. Any other value is rendered verbatim.
+const (
+	// footerNone renders no footer.
+	footerNone = "none"
+	// footerGenerated renders the plugin and compiler that generated the
+	// documentation, see generatedFooter.
+	footerGenerated = "generated"
+)
+
+// GenerationMeta describes how the documentation was generated, available
+// to templates as .Meta.
+type GenerationMeta struct {
+	// Plugin is the name of the plugin, "protoc-gen-apidocs".
+	Plugin string
+	// PluginVersion is the module version the plugin was built from, or
+	// empty for development builds.
+	PluginVersion string
+	// CompilerVersion is the version of protoc, or of the compiler standing
+	// in for it, e.g. "3.21.12". It is empty when not reported.
+	CompilerVersion string
+	// Parameter holds the parameters the plugin was invoked with.
+	Parameter string
+	// Source, Package and Syntax are the path of the .proto file the
+	// document was generated from, e.g. "acme/v1/user.proto", its package
+	// and its syntax, "proto2" or "proto3". They are empty for documents
+	// covering several files, such as combined documents and the index.
+	Source  string
+	Package string
+	Syntax  string
+	// Footer is the text of the footer, or empty when none is rendered.
+	// Templates render it when it is set.
+	Footer string
+}
+
+// generationMeta returns the metadata of the generation requested by req.
+// The generated footer holds no timestamp, so output doesn't change between
+// runs.
+func (o *GenOpts) generationMeta(req *pluginpb.CodeGeneratorRequest) GenerationMeta {
+	meta := GenerationMeta{
+		Plugin:        pluginName,
+		PluginVersion: pluginVersion(),
+		Parameter:     req.GetParameter(),
+	}
+	if v := req.GetCompilerVersion(); v != nil {
+		meta.CompilerVersion = fmt.Sprintf("%d.%d.%d%s", v.GetMajor(), v.GetMinor(), v.GetPatch(), v.GetSuffix())
+	}
+	switch o.Footer {
+	case "", footerNone:
+	case footerGenerated:
+		meta.Footer = meta.generatedFooter()
+	default:
+		meta.Footer = o.Footer
+	}
+	return meta
+}
+
+// fileMeta returns o.meta for the document of file f, or as is for
+// documents covering several files, when f is nil.
+func (o *GenOpts) fileMeta(f *protogen.File) GenerationMeta {
+	meta := o.meta
+	if f == nil {
+		return meta
+	}
+	meta.Source = f.Desc.Path()
+	meta.Package = string(f.Desc.Package())
+	meta.Syntax = f.Desc.Syntax().String()
+	if o.Footer == footerGenerated {
+		meta.Footer = meta.generatedFooter()
+	}
+	return meta
+}
+
+// generatedFooter returns the text of the generated footer, e.g.
+// "Generated from acme/v1/user.proto by protoc-gen-apidocs v1.2.0 with
+// protoc 3.21.12.", without the source for documents of several files.
+func (m GenerationMeta) generatedFooter() string {
+	s := "Generated"
+	if m.Source != "" {
+		s += " from " + m.Source
+	}
+	s += " by " + m.Plugin
+	if m.PluginVersion != "" {
+		s += " " + m.PluginVersion
+	}
+	if m.CompilerVersion != "" {
+		s += " with protoc " + m.CompilerVersion
+	}
+	return s + "."
+}
+
+// pluginVersion returns buildVersion or else the module version of the
+// plugin binary, or "" when it was built from a working tree.
+func pluginVersion() string {
+	if buildVersion != "" {
+		return buildVersion
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "(devel)" {
+		return ""
+	}
+	return info.Main.Version
+}
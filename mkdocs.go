@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"gopkg.in/yaml.v3"
+)
+
+// generateMkdocsNav writes a MkDocs nav fragment to o.MkdocsNav, below
+// OutSubdir like the documents, listing pages grouped by proto package, in
+// the order protoc passed the files. Page paths are relative to the
+// directory of the nav file, so it should be placed in the MkDocs docs_dir.
+func (o *GenOpts) generateMkdocsNav(gen *protogen.Plugin, pages []generatedPage) error {
+	var (
+		nav      []map[string][]map[string]string
+		packages = make(map[string]int)
+	)
+	filename := o.outPath(o.MkdocsNav)
+	dir := path.Dir(filename)
+	for _, p := range pages {
+		pkg := string(p.File.Desc.Package())
+		i, ok := packages[pkg]
+		if !ok {
+			i = len(nav)
+			packages[pkg] = i
+			nav = append(nav, map[string][]map[string]string{pkg: nil})
+		}
+		page := p.Filename
+		if rel, err := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(page)); err == nil {
+			page = filepath.ToSlash(rel)
+		}
+		nav[i][pkg] = append(nav[i][pkg], map[string]string{p.File.Desc.Path(): page})
+	}
+	enc := yaml.NewEncoder(gen.NewGeneratedFile(filename, ""))
+	enc.SetIndent(2)
+	if err := enc.Encode(map[string]interface{}{"nav": nav}); err != nil {
+		return fmt.Errorf("issue generating %v: %w", filename, err)
+	}
+	return enc.Close()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tmc/protoc-gen-apidocs/model"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gopkg.in/yaml.v3"
+)
+
+// Field numbers of google.protobuf.FileDescriptorProto, used to look up
+// source locations.
+const (
+	filePackageFieldNumber = 2
+	fileSyntaxFieldNumber  = 12
+)
+
+// newFileModel builds the model for file. Declarations keep their source order.
+func newFileModel(file *protogen.File) *model.File {
+	m := &model.File{
+		Name:        file.Desc.Path(),
+		Package:     string(file.Desc.Package()),
+		Syntax:      file.Desc.Syntax().String(),
+		Description: commentText(fileComment(file)),
+		Services:    []*model.Service{},
+		Messages:    []*model.Message{},
+		Enums:       []*model.Enum{},
+	}
+	for _, s := range file.Services {
+		m.Services = append(m.Services, newServiceModel(s))
+	}
+	for _, msg := range file.Messages {
+		m.Messages = append(m.Messages, newMessageModel(msg))
+	}
+	for _, e := range file.Enums {
+		m.Enums = append(m.Enums, newEnumModel(e))
+	}
+	return m
+}
+
+func newServiceModel(s *protogen.Service) *model.Service {
+	m := &model.Service{
+		Name:        string(s.Desc.Name()),
+		FullName:    string(s.Desc.FullName()),
+		Description: commentSetText(s.Comments),
+		Deprecated:  isDeprecated(s.Desc),
+		Methods:     []*model.Method{},
+	}
+	for _, method := range s.Methods {
+		var rules []*model.HTTPRule
+		for _, r := range httpRules(method) {
+			rules = append(rules, &model.HTTPRule{
+				Method:       r.Method,
+				Path:         r.Path,
+				Body:         r.Body,
+				ResponseBody: r.ResponseBody,
+			})
+		}
+		m.Methods = append(m.Methods, &model.Method{
+			Name:            string(method.Desc.Name()),
+			FullName:        string(method.Desc.FullName()),
+			Description:     commentSetText(method.Comments),
+			Deprecated:      isDeprecated(method.Desc),
+			InputType:       string(method.Input.Desc.FullName()),
+			OutputType:      string(method.Output.Desc.FullName()),
+			ClientStreaming: method.Desc.IsStreamingClient(),
+			ServerStreaming: method.Desc.IsStreamingServer(),
+			HTTPRules:       rules,
+		})
+	}
+	return m
+}
+
+func newMessageModel(msg *protogen.Message) *model.Message {
+	m := &model.Message{
+		Name:        string(msg.Desc.Name()),
+		LongName:    longName(msg.Desc),
+		FullName:    string(msg.Desc.FullName()),
+		Description: commentSetText(msg.Comments),
+		Deprecated:  isDeprecated(msg.Desc),
+		Fields:      []*model.Field{},
+	}
+	for _, f := range msg.Fields {
+		field := &model.Field{
+			Name:        string(f.Desc.Name()),
+			JSONName:    f.Desc.JSONName(),
+			Number:      int32(f.Desc.Number()),
+			Label:       fieldLabel(f),
+			Kind:        f.Desc.Kind().String(),
+			Type:        fieldType(f),
+			FullType:    fullFieldType(f),
+			Description: commentSetText(f.Comments),
+			Deprecated:  isDeprecated(f.Desc),
+		}
+		if f.Desc.IsMap() {
+			field.Type = mapType(f)
+			field.MapKeyType = fullFieldType(f.Message.Fields[0])
+			field.MapValueType = fullFieldType(f.Message.Fields[1])
+			field.FullType = fmt.Sprintf("map<%s, %s>", field.MapKeyType, field.MapValueType)
+		}
+		if inRealOneof(f) {
+			field.Oneof = string(f.Oneof.Desc.Name())
+		}
+		m.Fields = append(m.Fields, field)
+	}
+	for _, o := range oneofs(msg) {
+		oneof := &model.Oneof{
+			Name:        string(o.Desc.Name()),
+			Description: commentSetText(o.Comments),
+			Fields:      []string{},
+		}
+		for _, f := range o.Fields {
+			oneof.Fields = append(oneof.Fields, string(f.Desc.Name()))
+		}
+		m.Oneofs = append(m.Oneofs, oneof)
+	}
+	for _, nested := range nestedMessages(msg) {
+		m.Messages = append(m.Messages, newMessageModel(nested))
+	}
+	for _, e := range msg.Enums {
+		m.Enums = append(m.Enums, newEnumModel(e))
+	}
+	return m
+}
+
+func newEnumModel(e *protogen.Enum) *model.Enum {
+	m := &model.Enum{
+		Name:        string(e.Desc.Name()),
+		LongName:    longName(e.Desc),
+		FullName:    string(e.Desc.FullName()),
+		Description: commentSetText(e.Comments),
+		Deprecated:  isDeprecated(e.Desc),
+		Values:      []*model.EnumValue{},
+	}
+	for _, v := range enumValues(e) {
+		m.Values = append(m.Values, &model.EnumValue{
+			Name:        string(v.Desc.Name()),
+			Number:      int32(v.Desc.Number()),
+			Description: commentSetText(v.Comments),
+			Deprecated:  isDeprecated(v.Desc),
+		})
+	}
+	return m
+}
+
+// fileComment returns the leading comment of the syntax statement, or of
+// the package statement when the former has none.
+func fileComment(file *protogen.File) string {
+	locs := file.Desc.SourceLocations()
+	for _, field := range []int32{fileSyntaxFieldNumber, filePackageFieldNumber} {
+		if c := locs.ByPath(protoreflect.SourcePath{field}).LeadingComments; c != "" {
+			return c
+		}
+	}
+	return ""
+}
+
+// commentText returns a comment with comment markers and the whitespace
+// around each line removed.
+func commentText(c interface{}) string {
+	lines := strings.Split(description(c), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// commentSetText joins the leading and trailing comments of a declaration.
+func commentSetText(c protogen.CommentSet) string {
+	var parts []string
+	for _, s := range []string{commentText(c.Leading), commentText(c.Trailing)} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// newModel returns the model of the file being rendered, or of every file
+// when the files are combined.
+func newModel(data *TemplateData) interface{} {
+	if data.File != nil {
+		return newFileModel(data.File)
+	}
+	doc := &model.Document{Files: []*model.File{}}
+	for _, f := range data.Files {
+		doc.Files = append(doc.Files, newFileModel(f))
+	}
+	return doc
+}
+
+func (o *GenOpts) renderJSON(data *TemplateData, w io.Writer) error {
+	b, err := json.MarshalIndent(newModel(data), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// renderYAML writes the model as YAML. Multi-line descriptions are emitted
+// as literal block scalars.
+func (o *GenOpts) renderYAML(data *TemplateData, w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(newModel(data)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
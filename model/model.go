@@ -0,0 +1,120 @@
+// Package model defines the structured representation of documented .proto
+// files emitted by the json and yaml output formats of protoc-gen-apidocs.
+//
+// Slices preserve the declaration order of the source .proto file, so the
+// encoded output is stable across runs.
+package model
+
+// Document holds every file rendered into a combined document.
+type Document struct {
+	Files []*File `json:"files" yaml:"files"`
+}
+
+// File is a documented .proto file.
+type File struct {
+	Name        string     `json:"name" yaml:"name"`
+	Package     string     `json:"package" yaml:"package"`
+	Syntax      string     `json:"syntax" yaml:"syntax"`
+	Description string     `json:"description,omitempty" yaml:"description,omitempty"`
+	Services    []*Service `json:"services" yaml:"services"`
+	Messages    []*Message `json:"messages" yaml:"messages"`
+	Enums       []*Enum    `json:"enums" yaml:"enums"`
+}
+
+// Service is a gRPC service and its methods.
+type Service struct {
+	Name        string    `json:"name" yaml:"name"`
+	FullName    string    `json:"full_name" yaml:"full_name"`
+	Description string    `json:"description,omitempty" yaml:"description,omitempty"`
+	Deprecated  bool      `json:"deprecated" yaml:"deprecated"`
+	Methods     []*Method `json:"methods" yaml:"methods"`
+}
+
+// Method is a single RPC of a service. Input and output types are fully
+// qualified message names.
+type Method struct {
+	Name            string `json:"name" yaml:"name"`
+	FullName        string `json:"full_name" yaml:"full_name"`
+	Description     string `json:"description,omitempty" yaml:"description,omitempty"`
+	Deprecated      bool   `json:"deprecated" yaml:"deprecated"`
+	InputType       string `json:"input_type" yaml:"input_type"`
+	OutputType      string `json:"output_type" yaml:"output_type"`
+	ClientStreaming bool   `json:"client_streaming" yaml:"client_streaming"`
+	ServerStreaming bool   `json:"server_streaming" yaml:"server_streaming"`
+	// HTTPRules holds the REST mappings from the google.api.http option,
+	// the primary binding first.
+	HTTPRules []*HTTPRule `json:"http_rules,omitempty" yaml:"http_rules,omitempty"`
+}
+
+// HTTPRule maps a method to an HTTP method and URL path template.
+type HTTPRule struct {
+	Method       string `json:"method" yaml:"method"`
+	Path         string `json:"path" yaml:"path"`
+	Body         string `json:"body,omitempty" yaml:"body,omitempty"`
+	ResponseBody string `json:"response_body,omitempty" yaml:"response_body,omitempty"`
+}
+
+// Message is a message type, including the messages and enums nested in it.
+type Message struct {
+	Name        string     `json:"name" yaml:"name"`
+	LongName    string     `json:"long_name" yaml:"long_name"`
+	FullName    string     `json:"full_name" yaml:"full_name"`
+	Description string     `json:"description,omitempty" yaml:"description,omitempty"`
+	Deprecated  bool       `json:"deprecated" yaml:"deprecated"`
+	Fields      []*Field   `json:"fields" yaml:"fields"`
+	Oneofs      []*Oneof   `json:"oneofs,omitempty" yaml:"oneofs,omitempty"`
+	Messages    []*Message `json:"messages,omitempty" yaml:"messages,omitempty"`
+	Enums       []*Enum    `json:"enums,omitempty" yaml:"enums,omitempty"`
+}
+
+// Field is a field of a message.
+//
+// Label is "repeated", "required" or "optional" as declared in the source,
+// "map" for map fields, and empty for singular proto3 fields. Kind is the
+// protobuf kind, e.g. "string" or "message". Type is the short type name
+// used in the rendered documentation and FullType is the fully qualified
+// name of message and enum types. Map fields are typed as declared, e.g.
+// "map<string, Label>", rather than by the entry messages protoc synthesizes
+// for them, which messages leave out; MapKeyType and MapValueType are their
+// key and value types, with the fully qualified names of message and enum
+// types.
+type Field struct {
+	Name         string `json:"name" yaml:"name"`
+	JSONName     string `json:"json_name" yaml:"json_name"`
+	Number       int32  `json:"number" yaml:"number"`
+	Label        string `json:"label,omitempty" yaml:"label,omitempty"`
+	Kind         string `json:"kind" yaml:"kind"`
+	Type         string `json:"type" yaml:"type"`
+	FullType     string `json:"full_type" yaml:"full_type"`
+	MapKeyType   string `json:"map_key_type,omitempty" yaml:"map_key_type,omitempty"`
+	MapValueType string `json:"map_value_type,omitempty" yaml:"map_value_type,omitempty"`
+	Oneof        string `json:"oneof,omitempty" yaml:"oneof,omitempty"`
+	Description  string `json:"description,omitempty" yaml:"description,omitempty"`
+	Deprecated   bool   `json:"deprecated" yaml:"deprecated"`
+}
+
+// Oneof is a oneof of a message. Synthetic oneofs generated for proto3
+// optional fields are not included.
+type Oneof struct {
+	Name        string   `json:"name" yaml:"name"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Fields      []string `json:"fields" yaml:"fields"`
+}
+
+// Enum is an enum type.
+type Enum struct {
+	Name        string       `json:"name" yaml:"name"`
+	LongName    string       `json:"long_name" yaml:"long_name"`
+	FullName    string       `json:"full_name" yaml:"full_name"`
+	Description string       `json:"description,omitempty" yaml:"description,omitempty"`
+	Deprecated  bool         `json:"deprecated" yaml:"deprecated"`
+	Values      []*EnumValue `json:"values" yaml:"values"`
+}
+
+// EnumValue is a single value of an enum.
+type EnumValue struct {
+	Name        string `json:"name" yaml:"name"`
+	Number      int32  `json:"number" yaml:"number"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Deprecated  bool   `json:"deprecated" yaml:"deprecated"`
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIVersion is the version of the OpenAPI specification generated by
+// the openapi format.
+const openAPIVersion = "3.0.3"
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `yaml:"openapi" json:"openapi"`
+	Info       openAPIInfo                             `yaml:"info" json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `yaml:"paths" json:"paths"`
+	Components openAPIComponents                       `yaml:"components,omitempty" json:"components,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title       string `yaml:"title" json:"title"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Version     string `yaml:"version" json:"version"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `yaml:"operationId" json:"operationId"`
+	Description string                      `yaml:"description,omitempty" json:"description,omitempty"`
+	Tags        []string                    `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Deprecated  bool                        `yaml:"deprecated,omitempty" json:"deprecated,omitempty"`
+	Parameters  []*openAPIParameter         `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `yaml:"requestBody,omitempty" json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `yaml:"responses" json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `yaml:"name" json:"name"`
+	In          string         `yaml:"in" json:"in"`
+	Description string         `yaml:"description,omitempty" json:"description,omitempty"`
+	Required    bool           `yaml:"required,omitempty" json:"required,omitempty"`
+	Schema      *openAPISchema `yaml:"schema" json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `yaml:"required" json:"required"`
+	Content  map[string]*openAPIMediaType `yaml:"content" json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `yaml:"description" json:"description"`
+	Content     map[string]*openAPIMediaType `yaml:"content,omitempty" json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `yaml:"schema" json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `yaml:"schemas,omitempty" json:"schemas,omitempty"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `yaml:"$ref,omitempty" json:"$ref,omitempty"`
+	Type                 string                    `yaml:"type,omitempty" json:"type,omitempty"`
+	Format               string                    `yaml:"format,omitempty" json:"format,omitempty"`
+	Description          string                    `yaml:"description,omitempty" json:"description,omitempty"`
+	Enum                 []string                  `yaml:"enum,omitempty" json:"enum,omitempty"`
+	Items                *openAPISchema            `yaml:"items,omitempty" json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `yaml:"properties,omitempty" json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `yaml:"additionalProperties,omitempty" json:"additionalProperties,omitempty"`
+	Deprecated           bool                      `yaml:"deprecated,omitempty" json:"deprecated,omitempty"`
+}
+
+// scalarSchemas maps scalar kinds to their schema under the proto3 JSON
+// mapping, which represents 64-bit integers as strings.
+var scalarSchemas = map[protoreflect.Kind]openAPISchema{
+	protoreflect.BoolKind:     {Type: "boolean"},
+	protoreflect.Int32Kind:    {Type: "integer", Format: "int32"},
+	protoreflect.Sint32Kind:   {Type: "integer", Format: "int32"},
+	protoreflect.Sfixed32Kind: {Type: "integer", Format: "int32"},
+	protoreflect.Uint32Kind:   {Type: "integer", Format: "int64"},
+	protoreflect.Fixed32Kind:  {Type: "integer", Format: "int64"},
+	protoreflect.Int64Kind:    {Type: "string", Format: "int64"},
+	protoreflect.Sint64Kind:   {Type: "string", Format: "int64"},
+	protoreflect.Sfixed64Kind: {Type: "string", Format: "int64"},
+	protoreflect.Uint64Kind:   {Type: "string", Format: "uint64"},
+	protoreflect.Fixed64Kind:  {Type: "string", Format: "uint64"},
+	protoreflect.FloatKind:    {Type: "number", Format: "float"},
+	protoreflect.DoubleKind:   {Type: "number", Format: "double"},
+	protoreflect.StringKind:   {Type: "string"},
+	protoreflect.BytesKind:    {Type: "string", Format: "byte"},
+}
+
+// wellKnownSchemas maps well-known types that have a special JSON
+// representation to their schema.
+var wellKnownSchemas = map[protoreflect.FullName]openAPISchema{
+	"google.protobuf.Timestamp":   {Type: "string", Format: "date-time"},
+	"google.protobuf.Duration":    {Type: "string"},
+	"google.protobuf.FieldMask":   {Type: "string"},
+	"google.protobuf.Empty":       {Type: "object"},
+	"google.protobuf.Struct":      {Type: "object"},
+	"google.protobuf.Value":       {},
+	"google.protobuf.ListValue":   {Type: "array", Items: &openAPISchema{}},
+	"google.protobuf.Any":         {Type: "object"},
+	"google.protobuf.BoolValue":   {Type: "boolean"},
+	"google.protobuf.Int32Value":  {Type: "integer", Format: "int32"},
+	"google.protobuf.UInt32Value": {Type: "integer", Format: "int64"},
+	"google.protobuf.Int64Value":  {Type: "string", Format: "int64"},
+	"google.protobuf.UInt64Value": {Type: "string", Format: "uint64"},
+	"google.protobuf.FloatValue":  {Type: "number", Format: "float"},
+	"google.protobuf.DoubleValue": {Type: "number", Format: "double"},
+	"google.protobuf.StringValue": {Type: "string"},
+	"google.protobuf.BytesValue":  {Type: "string", Format: "byte"},
+}
+
+// pathParamPattern matches the variables of a path template, e.g. "{id}" or
+// "{name=shelves/*}".
+var pathParamPattern = regexp.MustCompile(`\{([^}=]+)(=[^}]*)?\}`)
+
+// openAPIVersion returns the info version of OpenAPI documents, which the
+// specification requires: the configured version, or "1.0.0".
+func (o *GenOpts) openAPIVersion() string {
+	if o.Version != "" {
+		return o.Version
+	}
+	return "1.0.0"
+}
+
+// renderOpenAPI writes the OpenAPI document of data as YAML.
+func (o *GenOpts) renderOpenAPI(data *TemplateData, w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	doc, err := o.openAPIDocument(data)
+	if err != nil {
+		return err
+	}
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// renderOpenAPIJSON writes the OpenAPI document of data as JSON, e.g. for
+// Swagger UI.
+func (o *GenOpts) renderOpenAPIJSON(data *TemplateData, w io.Writer) error {
+	doc, err := o.openAPIDocument(data)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// openAPIDocument returns an OpenAPI 3 document describing the methods of
+// data that have a google.api.http option. Other methods are skipped with a
+// warning. The title and description are those of the title and description
+// options, and default to the package of a single file. Bindings naming
+// fields that don't exist, and bindings of the same HTTP method and path, are
+// an error.
+func (o *GenOpts) openAPIDocument(data *TemplateData) (*openAPIDocument, error) {
+	b := &openAPIBuilder{schemas: make(map[string]*openAPISchema)}
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info:    openAPIInfo{Title: o.title(), Version: o.openAPIVersion(), Description: o.Description},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+	}
+	// bound holds the methods by the HTTP method and path bound to them.
+	bound := make(map[[2]string]*protogen.Method)
+	if data.File != nil {
+		if o.Title == "" {
+			doc.Info.Title = string(data.Desc.Package())
+		}
+		if o.Description == "" {
+			doc.Info.Description = fmt.Sprintf("API Specification for the %s package.", data.Desc.Package())
+		}
+	}
+	for _, f := range data.Files {
+		for _, s := range f.Services {
+			for _, m := range s.Methods {
+				rules := httpRules(m)
+				if len(rules) == 0 {
+					fmt.Fprintf(os.Stderr, "%s: skipping %v: no google.api.http option\n", pluginName, m.Desc.FullName())
+					continue
+				}
+				for i, r := range rules {
+					method := strings.ToLower(r.Method)
+					switch method {
+					case "get", "put", "post", "delete", "patch", "head", "options", "trace":
+					default:
+						fmt.Fprintf(os.Stderr, "%s: skipping %v: unsupported HTTP method %q\n", pluginName, m.Desc.FullName(), r.Method)
+						continue
+					}
+					path := pathParamPattern.ReplaceAllString(r.Path, "{$1}")
+					if other, ok := bound[[2]string{method, path}]; ok {
+						return nil, fmt.Errorf("%v: %s %s is also bound to %v", m.Desc.FullName(), r.Method, r.Path, other.Desc.FullName())
+					}
+					bound[[2]string{method, path}] = m
+					if doc.Paths[path] == nil {
+						doc.Paths[path] = make(map[string]*openAPIOperation)
+					}
+					op, err := b.operation(s, m, r)
+					if err != nil {
+						return nil, err
+					}
+					if i > 0 {
+						// Operation IDs must be unique, number the
+						// additional bindings.
+						op.OperationID = fmt.Sprintf("%s%d", op.OperationID, i+1)
+					}
+					doc.Paths[path][method] = op
+				}
+			}
+		}
+	}
+	doc.Components.Schemas = b.schemas
+	return doc, nil
+}
+
+// openAPIBuilder collects the component schemas referenced by operations.
+type openAPIBuilder struct {
+	schemas map[string]*openAPISchema
+}
+
+// operation returns the operation of the binding r of m. Like Postman
+// requests, it is an error for the body or response body of r to name a
+// field that doesn't exist.
+func (b *openAPIBuilder) operation(s *protogen.Service, m *protogen.Method, r HTTPRule) (*openAPIOperation, error) {
+	op := &openAPIOperation{
+		OperationID: fmt.Sprintf("%s_%s", s.Desc.Name(), m.Desc.Name()),
+		Description: commentSetText(m.Comments),
+		Tags:        []string{string(s.Desc.Name())},
+		Deprecated:  isDeprecated(m.Desc),
+		Responses:   make(map[string]*openAPIResponse),
+	}
+	bound := make(map[string]bool)
+	for _, match := range pathParamPattern.FindAllStringSubmatch(r.Path, -1) {
+		name := match[1]
+		bound[name] = true
+		param := &openAPIParameter{Name: name, In: "path", Required: true, Schema: &openAPISchema{Type: "string"}}
+		if f := findFieldPath(m.Input, name); f != nil {
+			param.Description = commentSetText(f.Comments)
+			param.Schema = b.parameterSchema(f)
+		}
+		op.Parameters = append(op.Parameters, param)
+	}
+	switch r.Body {
+	case "":
+		op.Parameters = append(op.Parameters, b.queryParameters(m.Input, bound)...)
+	case "*":
+		op.RequestBody = b.requestBody(b.messageSchema(m.Input))
+	default:
+		f := findFieldPath(m.Input, r.Body)
+		if f == nil {
+			return nil, fmt.Errorf("%v: body field %q not found in %v", m.Desc.FullName(), r.Body, m.Input.Desc.FullName())
+		}
+		op.RequestBody = b.requestBody(b.fieldSchema(f))
+		bound[r.Body] = true
+		op.Parameters = append(op.Parameters, b.queryParameters(m.Input, bound)...)
+	}
+	response := b.messageSchema(m.Output)
+	if r.ResponseBody != "" {
+		f := findFieldPath(m.Output, r.ResponseBody)
+		if f == nil {
+			return nil, fmt.Errorf("%v: response body field %q not found in %v", m.Desc.FullName(), r.ResponseBody, m.Output.Desc.FullName())
+		}
+		response = b.fieldSchema(f)
+	}
+	op.Responses["200"] = &openAPIResponse{
+		Description: "A successful response.",
+		Content:     map[string]*openAPIMediaType{"application/json": {Schema: response}},
+	}
+	return op, nil
+}
+
+func (b *openAPIBuilder) requestBody(schema *openAPISchema) *openAPIRequestBody {
+	return &openAPIRequestBody{
+		Required: true,
+		Content:  map[string]*openAPIMediaType{"application/json": {Schema: schema}},
+	}
+}
+
+// queryParameters returns the fields of msg that are not bound to the path
+// or body as query parameters, see queryFields.
+func (b *openAPIBuilder) queryParameters(msg *protogen.Message, bound map[string]bool) []*openAPIParameter {
+	var params []*openAPIParameter
+	for _, f := range queryFields(msg, bound) {
+		params = append(params, &openAPIParameter{
+			Name:        f.Desc.JSONName(),
+			In:          "query",
+			Description: commentSetText(f.Comments),
+			Schema:      b.parameterSchema(f),
+		})
+	}
+	return params
+}
+
+// queryFields returns the fields of msg that are not bound to the path or
+// body, which are passed as query parameters. Message fields are left out,
+// other than well-known types with a scalar representation.
+func queryFields(msg *protogen.Message, bound map[string]bool) []*protogen.Field {
+	var fields []*protogen.Field
+	for _, f := range msg.Fields {
+		if bound[string(f.Desc.Name())] || f.Desc.IsMap() {
+			continue
+		}
+		if f.Message != nil {
+			if _, ok := wellKnownSchemas[f.Message.Desc.FullName()]; !ok {
+				continue
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// parameterSchema returns the schema of f as a parameter, whose description
+// is carried by the parameter itself.
+func (b *openAPIBuilder) parameterSchema(f *protogen.Field) *openAPISchema {
+	schema := b.fieldSchema(f)
+	schema.Description = ""
+	return schema
+}
+
+// fieldSchema returns the schema of the values of f.
+func (b *openAPIBuilder) fieldSchema(f *protogen.Field) *openAPISchema {
+	if f.Desc.IsMap() {
+		return &openAPISchema{
+			Type:                 "object",
+			Description:          commentSetText(f.Comments),
+			AdditionalProperties: b.singularSchema(f.Message.Fields[1]),
+		}
+	}
+	schema := b.singularSchema(f)
+	if f.Desc.IsList() {
+		schema = &openAPISchema{Type: "array", Items: schema}
+	}
+	if schema.Ref == "" {
+		schema.Description = commentSetText(f.Comments)
+		schema.Deprecated = isDeprecated(f.Desc)
+	}
+	return schema
+}
+
+// singularSchema returns the schema of a single value of f.
+func (b *openAPIBuilder) singularSchema(f *protogen.Field) *openAPISchema {
+	switch {
+	case f.Message != nil:
+		return b.messageSchema(f.Message)
+	case f.Enum != nil:
+		return b.enumSchema(f.Enum)
+	}
+	schema := scalarSchemas[f.Desc.Kind()]
+	return &schema
+}
+
+// messageSchema returns a reference to the component schema of msg, adding
+// it and the schemas of its fields if necessary.
+func (b *openAPIBuilder) messageSchema(msg *protogen.Message) *openAPISchema {
+	if schema, ok := wellKnownSchemas[msg.Desc.FullName()]; ok {
+		return &schema
+	}
+	name := string(msg.Desc.FullName())
+	ref := &openAPISchema{Ref: "#/components/schemas/" + name}
+	if _, ok := b.schemas[name]; ok {
+		return ref
+	}
+	schema := &openAPISchema{
+		Type:        "object",
+		Description: commentSetText(msg.Comments),
+		Deprecated:  isDeprecated(msg.Desc),
+		Properties:  make(map[string]*openAPISchema),
+	}
+	// Register the schema before visiting the fields so that recursive
+	// messages refer to it.
+	b.schemas[name] = schema
+	for _, f := range msg.Fields {
+		schema.Properties[f.Desc.JSONName()] = b.fieldSchema(f)
+	}
+	return ref
+}
+
+// enumSchema returns a reference to the component schema of e.
+func (b *openAPIBuilder) enumSchema(e *protogen.Enum) *openAPISchema {
+	name := string(e.Desc.FullName())
+	if _, ok := b.schemas[name]; !ok {
+		schema := &openAPISchema{
+			Type:        "string",
+			Description: commentSetText(e.Comments),
+			Deprecated:  isDeprecated(e.Desc),
+		}
+		for _, v := range e.Values {
+			schema.Enum = append(schema.Enum, string(v.Desc.Name()))
+		}
+		b.schemas[name] = schema
+	}
+	return &openAPISchema{Ref: "#/components/schemas/" + name}
+}
+
+// findFieldPath returns the field of msg named by a dotted path such as
+// "shelf.id", or nil if there is none.
+func findFieldPath(msg *protogen.Message, path string) *protogen.Field {
+	var field *protogen.Field
+	for _, name := range strings.Split(path, ".") {
+		if msg == nil {
+			return nil
+		}
+		field = nil
+		for _, f := range msg.Fields {
+			if string(f.Desc.Name()) == name {
+				field = f
+			}
+		}
+		if field == nil {
+			return nil
+		}
+		msg = field.Message
+	}
+	return field
+}
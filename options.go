@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// findExtension returns the extension named name declared by file or any
+// file it imports, or nil if there is none. Options defined by other protos,
+// such as google.api.http, are looked up this way since their Go packages
+// are not linked into the plugin.
+func findExtension(file protoreflect.FileDescriptor, name protoreflect.FullName) protoreflect.ExtensionDescriptor {
+	seen := make(map[string]bool)
+	var find func(f protoreflect.FileDescriptor) protoreflect.ExtensionDescriptor
+	find = func(f protoreflect.FileDescriptor) protoreflect.ExtensionDescriptor {
+		if seen[f.Path()] {
+			return nil
+		}
+		seen[f.Path()] = true
+		if f.Package() == name.Parent() {
+			if xd := f.Extensions().ByName(name.Name()); xd != nil {
+				return xd
+			}
+		}
+		imports := f.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			if xd := find(imports.Get(i).FileDescriptor); xd != nil {
+				return xd
+			}
+		}
+		return nil
+	}
+	return find(file)
+}
+
+// extensionValue returns the value of the extension named name on the
+// options of d, and whether it is set.
+func extensionValue(d protoreflect.Descriptor, name protoreflect.FullName) (protoreflect.Value, bool) {
+	xd := findExtension(d.ParentFile(), name)
+	if xd == nil {
+		return protoreflect.Value{}, false
+	}
+	opts, ok := d.Options().(proto.Message)
+	if !ok || opts == nil {
+		return protoreflect.Value{}, false
+	}
+	// The extension was parsed as an unknown field; parse it again with a
+	// resolver that knows it.
+	b, err := proto.Marshal(opts)
+	if err != nil {
+		return protoreflect.Value{}, false
+	}
+	xt := dynamicpb.NewExtensionType(xd)
+	types := new(protoregistry.Types)
+	if err := types.RegisterExtension(xt); err != nil {
+		return protoreflect.Value{}, false
+	}
+	resolved := dynamicpb.NewMessage(xd.ContainingMessage())
+	if err := (proto.UnmarshalOptions{Resolver: types}).Unmarshal(b, resolved); err != nil {
+		return protoreflect.Value{}, false
+	}
+	if !resolved.Has(xt.TypeDescriptor()) {
+		return protoreflect.Value{}, false
+	}
+	return resolved.Get(xt.TypeDescriptor()), true
+}
+
+// CustomOption is a custom option set on a declaration: the full name of its
+// extension, e.g. acme.sensitive, and its value in the style of the text
+// format, e.g. true, "ms", INTERNAL or {team: "audit"}.
+type CustomOption struct {
+	Name  string
+	Value string
+}
+
+// customOptions returns the custom options set on d, ordered by field
+// number. Options are resolved against the extensions declared by the file
+// of d and its imports; others remain unknown fields and are skipped.
+func customOptions(d protoreflect.Descriptor) []CustomOption {
+	opts, ok := d.Options().(proto.Message)
+	if !ok || opts == nil {
+		return nil
+	}
+	name := opts.ProtoReflect().Descriptor().FullName()
+	types := new(protoregistry.Types)
+	var containing protoreflect.MessageDescriptor
+	for _, xd := range optionExtensions(d.ParentFile(), name) {
+		if types.RegisterExtension(dynamicpb.NewExtensionType(xd)) == nil && containing == nil {
+			containing = xd.ContainingMessage()
+		}
+	}
+	if containing == nil {
+		return nil
+	}
+	b, err := proto.Marshal(opts)
+	if err != nil {
+		return nil
+	}
+	resolved := dynamicpb.NewMessage(containing)
+	if err := (proto.UnmarshalOptions{Resolver: types}).Unmarshal(b, resolved); err != nil {
+		return nil
+	}
+	var options []CustomOption
+	for _, fv := range messageFields(resolved) {
+		if fv.fd.IsExtension() {
+			options = append(options, CustomOption{string(fv.fd.FullName()), formatOptionValue(fv.fd, fv.v)})
+		}
+	}
+	return options
+}
+
+// optionExtensions returns the extensions of the options message name
+// declared by file or any file it imports, including those declared in
+// messages.
+func optionExtensions(file protoreflect.FileDescriptor, name protoreflect.FullName) []protoreflect.ExtensionDescriptor {
+	var xds []protoreflect.ExtensionDescriptor
+	add := func(exts protoreflect.ExtensionDescriptors) {
+		for i := 0; i < exts.Len(); i++ {
+			if xd := exts.Get(i); xd.ContainingMessage().FullName() == name {
+				xds = append(xds, xd)
+			}
+		}
+	}
+	var addMessages func(msgs protoreflect.MessageDescriptors)
+	addMessages = func(msgs protoreflect.MessageDescriptors) {
+		for i := 0; i < msgs.Len(); i++ {
+			add(msgs.Get(i).Extensions())
+			addMessages(msgs.Get(i).Messages())
+		}
+	}
+	seen := make(map[string]bool)
+	var walk func(f protoreflect.FileDescriptor)
+	walk = func(f protoreflect.FileDescriptor) {
+		if seen[f.Path()] {
+			return
+		}
+		seen[f.Path()] = true
+		add(f.Extensions())
+		addMessages(f.Messages())
+		imports := f.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			walk(imports.Get(i).FileDescriptor)
+		}
+	}
+	walk(file)
+	return xds
+}
+
+type fieldValue struct {
+	fd protoreflect.FieldDescriptor
+	v  protoreflect.Value
+}
+
+// messageFields returns the fields set on m, ordered by number.
+func messageFields(m protoreflect.Message) []fieldValue {
+	var fields []fieldValue
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fields = append(fields, fieldValue{fd, v})
+		return true
+	})
+	sort.Slice(fields, func(i, j int) bool { return fields[i].fd.Number() < fields[j].fd.Number() })
+	return fields
+}
+
+// formatOptionValue formats the value v of the field fd in the style of the
+// text format, with lists in brackets and messages in braces.
+func formatOptionValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch {
+	case fd.IsList():
+		var elems []string
+		l := v.List()
+		for i := 0; i < l.Len(); i++ {
+			elems = append(elems, formatSingularValue(fd, l.Get(i)))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case fd.IsMap():
+		var entries []string
+		v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			entries = append(entries, formatSingularValue(fd.MapKey(), k.Value())+": "+formatSingularValue(fd.MapValue(), mv))
+			return true
+		})
+		sort.Strings(entries)
+		return "{" + strings.Join(entries, ", ") + "}"
+	}
+	return formatSingularValue(fd, v)
+}
+
+func formatSingularValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return strconv.Quote(v.String())
+	case protoreflect.BytesKind:
+		return strconv.Quote(string(v.Bytes()))
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		var fields []string
+		for _, fv := range messageFields(v.Message()) {
+			name := string(fv.fd.Name())
+			if fv.fd.IsExtension() {
+				name = "[" + string(fv.fd.FullName()) + "]"
+			}
+			fields = append(fields, name+": "+formatOptionValue(fv.fd, fv.v))
+		}
+		return "{" + strings.Join(fields, " ") + "}"
+	}
+	return fmt.Sprint(v.Interface())
+}
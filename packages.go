@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// patternsFlag collects the patterns of repeated include-package,
+// exclude-package, include or exclude parameters. Like templateDirsFlag,
+// each parameter may also hold a comma-separated list.
+type patternsFlag []string
+
+func (f *patternsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *patternsFlag) Set(s string) error {
+	for _, pattern := range strings.Split(s, ",") {
+		if pattern != "" {
+			*f = append(*f, pattern)
+		}
+	}
+	return nil
+}
+
+// checkPackagePatterns reports the first malformed pattern of
+// IncludePackages, ExcludePackages, IncludeFiles and ExcludeFiles.
+func (o *GenOpts) checkPackagePatterns() error {
+	for _, pattern := range append(append([]string(nil), o.IncludePackages...), o.ExcludePackages...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid package pattern %q: %v", pattern, err)
+		}
+	}
+	for _, pattern := range append(append([]string(nil), o.IncludeFiles...), o.ExcludeFiles...) {
+		if _, err := globRegexp(pattern); err != nil {
+			return fmt.Errorf("invalid file pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// skipReason returns why f is left out of the documentation by the package
+// and file patterns, or "" if it is documented.
+func (o *GenOpts) skipReason(f *protogen.File) string {
+	if !o.includesPackage(f.Desc.Package()) {
+		return fmt.Sprintf("package %s is not selected", f.Desc.Package())
+	}
+	if len(o.IncludeFiles) > 0 && matchFile(o.IncludeFiles, f.Desc) == "" {
+		return "no include pattern matches"
+	}
+	if pattern := matchFile(o.ExcludeFiles, f.Desc); pattern != "" {
+		return fmt.Sprintf("excluded by %q", pattern)
+	}
+	return ""
+}
+
+// matchFile returns the first of patterns matching the import path or the
+// package of fd, or "" if none does.
+func matchFile(patterns []string, fd protoreflect.FileDescriptor) string {
+	for _, pattern := range patterns {
+		re, err := globRegexp(pattern)
+		if err != nil {
+			continue
+		}
+		if re.MatchString(fd.Path()) || re.MatchString(string(fd.Package())) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// globRegexp compiles a path.Match style glob to a regular expression. In
+// addition to the path.Match syntax, "**" matches any number of path
+// elements, so "vendor/**" matches every file below vendor and
+// "**/internal.proto" matches internal.proto in any directory.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(pattern[i:], "**/"):
+				b.WriteString("(.*/)?")
+				i += 2
+			case strings.HasPrefix(pattern[i:], "**"):
+				b.WriteString(".*")
+				i++
+			default:
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			// path.Match validated the class, and its syntax of ranges,
+			// negation with ^ and escapes is that of regexp.
+			j := i + 1
+			for ; pattern[j] != ']'; j++ {
+				if pattern[j] == '\\' {
+					j++
+				}
+			}
+			b.WriteString(pattern[i : j+1])
+			i = j
+		case '\\':
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// includesPackage reports whether files of pkg are documented. A package is
+// documented when it matches an IncludePackages pattern, or there are none,
+// and matches no ExcludePackages pattern.
+func (o *GenOpts) includesPackage(pkg protoreflect.FullName) bool {
+	if len(o.IncludePackages) > 0 && !matchPackage(o.IncludePackages, pkg) {
+		return false
+	}
+	return !matchPackage(o.ExcludePackages, pkg)
+}
+
+// matchPackage reports whether pkg matches one of patterns. Patterns are
+// path.Match globs, so "com.acme.internal.*" matches the packages nested in
+// com.acme.internal; a pattern ending in ".*" matches the package itself as
+// well.
+func matchPackage(patterns []string, pkg protoreflect.FullName) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, string(pkg)); ok {
+			return true
+		}
+		if strings.HasSuffix(pattern, ".*") && strings.TrimSuffix(pattern, ".*") == string(pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipEmptyServices returns the files of files that define services, see
+// GenOpts.SkipEmptyServices. When keepReferenced is set, files without
+// services whose messages or enums are referenced from a file with services,
+// directly or through the fields of other messages, are kept as well.
+func (o *GenOpts) skipEmptyServices(files []*protogen.File, keepReferenced bool) []*protogen.File {
+	referenced := make(map[string]bool)
+	if keepReferenced {
+		w := newTypeWalker(true)
+		for _, f := range files {
+			if len(f.Services) == 0 {
+				continue
+			}
+			for _, s := range f.Services {
+				for _, m := range s.Methods {
+					w.walk(m.Input)
+					w.walk(m.Output)
+				}
+			}
+			for _, m := range f.Messages {
+				w.walk(m)
+			}
+		}
+		for _, m := range w.messages {
+			referenced[m.Desc.ParentFile().Path()] = true
+		}
+		for _, e := range w.enums {
+			referenced[e.Desc.ParentFile().Path()] = true
+		}
+	}
+	var kept []*protogen.File
+	for _, f := range files {
+		if len(f.Services) > 0 || referenced[f.Desc.Path()] {
+			kept = append(kept, f)
+		} else if o.Verbose {
+			fmt.Fprintf(os.Stderr, "%s: skipping %s: no services\n", pluginName, f.Desc.Path())
+		}
+	}
+	return kept
+}
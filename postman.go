@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// postmanSchema identifies the Postman collection format generated by the
+// postman format.
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+type postmanCollection struct {
+	Info     postmanInfo       `json:"info"`
+	Item     []*postmanFolder  `json:"item"`
+	Variable []postmanVariable `json:"variable"`
+}
+
+type postmanInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Schema      string `json:"schema"`
+}
+
+type postmanFolder struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Item        []*postmanItem `json:"item"`
+}
+
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Request *postmanRequest `json:"request"`
+}
+
+type postmanRequest struct {
+	Method      string          `json:"method"`
+	Header      []postmanHeader `json:"header"`
+	Body        *postmanBody    `json:"body,omitempty"`
+	URL         postmanURL      `json:"url"`
+	Description string          `json:"description,omitempty"`
+}
+
+type postmanHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type postmanBody struct {
+	Mode    string             `json:"mode"`
+	Raw     string             `json:"raw"`
+	Options postmanBodyOptions `json:"options"`
+}
+
+type postmanBodyOptions struct {
+	Raw struct {
+		Language string `json:"language"`
+	} `json:"raw"`
+}
+
+type postmanURL struct {
+	Raw      string            `json:"raw"`
+	Host     []string          `json:"host"`
+	Path     []string          `json:"path"`
+	Query    []postmanQuery    `json:"query,omitempty"`
+	Variable []postmanVariable `json:"variable,omitempty"`
+}
+
+type postmanQuery struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+	Disabled    bool   `json:"disabled"`
+}
+
+type postmanVariable struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+}
+
+// postmanBaseURL is the collection variable requests are relative to.
+const postmanBaseURL = "baseUrl"
+
+// renderPostman writes a Postman collection with a folder per service and a
+// request per HTTP binding of its methods. Methods without a google.api.http
+// option can't be called from Postman and are left out. Fields of the request
+// message bound to neither the path nor the body are listed as disabled query
+// parameters, to be enabled as needed.
+func (o *GenOpts) renderPostman(data *TemplateData, w io.Writer) error {
+	c := &postmanCollection{
+		Info:     postmanInfo{Name: o.title(), Schema: postmanSchema},
+		Item:     []*postmanFolder{},
+		Variable: []postmanVariable{{Key: postmanBaseURL, Value: "http://localhost:8080"}},
+	}
+	if data.File != nil {
+		c.Info.Name = string(data.Desc.Package())
+		c.Info.Description = commentText(fileComment(data.File))
+	}
+	for _, f := range data.Files {
+		for _, s := range f.Services {
+			folder := &postmanFolder{
+				Name:        string(s.Desc.Name()),
+				Description: commentSetText(s.Comments),
+				Item:        []*postmanItem{},
+			}
+			for _, m := range s.Methods {
+				for _, r := range httpRules(m) {
+					req, err := newPostmanRequest(m, r)
+					if err != nil {
+						return err
+					}
+					folder.Item = append(folder.Item, &postmanItem{Name: string(m.Desc.Name()), Request: req})
+				}
+			}
+			if len(folder.Item) > 0 {
+				c.Item = append(c.Item, folder)
+			}
+		}
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+func newPostmanRequest(m *protogen.Method, r HTTPRule) (*postmanRequest, error) {
+	req := &postmanRequest{
+		Method:      r.Method,
+		Header:      []postmanHeader{},
+		Description: commentSetText(m.Comments),
+		URL:         postmanURL{Host: []string{"{{" + postmanBaseURL + "}}"}},
+	}
+	bound := make(map[string]bool)
+	path := pathParamPattern.ReplaceAllStringFunc(r.Path, func(v string) string {
+		name := pathParamPattern.FindStringSubmatch(v)[1]
+		bound[name] = true
+		variable := postmanVariable{Key: name, Value: ""}
+		if f := findFieldPath(m.Input, name); f != nil {
+			variable.Description = commentSetText(f.Comments)
+		}
+		req.URL.Variable = append(req.URL.Variable, variable)
+		return ":" + name
+	})
+	req.URL.Raw = "{{" + postmanBaseURL + "}}" + path
+	req.URL.Path = strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if r.Body != "*" {
+		bound[r.Body] = true
+		for _, f := range queryFields(m.Input, bound) {
+			req.URL.Query = append(req.URL.Query, postmanQuery{
+				Key:         f.Desc.JSONName(),
+				Value:       examplePlainValue(exampleFieldValue(f, nil)),
+				Description: commentSetText(f.Comments),
+				Disabled:    true,
+			})
+		}
+	}
+
+	var example interface{}
+	switch r.Body {
+	case "":
+		return req, nil
+	case "*":
+		example = exampleMessageValue(m.Input, nil)
+	default:
+		f := findFieldPath(m.Input, r.Body)
+		if f == nil {
+			return nil, fmt.Errorf("%v: body field %q not found in %v", m.Desc.FullName(), r.Body, m.Input.Desc.FullName())
+		}
+		example = exampleFieldValue(f, nil)
+	}
+	raw, err := json.MarshalIndent(example, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	req.Header = append(req.Header, postmanHeader{Key: "Content-Type", Value: "application/json"})
+	req.Body = &postmanBody{Mode: "raw", Raw: string(raw)}
+	req.Body.Options.Raw.Language = "json"
+	return req, nil
+}
+
+// jsonObject is a JSON object whose members keep their order.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	Name  string
+	Value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(m.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonExample returns an indented example JSON document for msg with a
+// placeholder value per field.
+func jsonExample(msg *protogen.Message) (string, error) {
+	b, err := json.MarshalIndent(exampleMessageValue(msg, nil), "", "  ")
+	return string(b), err
+}
+
+// exampleMessageValue returns a placeholder JSON value for msg. Messages already
+// being expanded, listed in seen, are represented by an empty object so that
+// recursive messages terminate.
+func exampleMessageValue(msg *protogen.Message, seen []protoreflect.FullName) interface{} {
+	for _, name := range seen {
+		if name == msg.Desc.FullName() {
+			return jsonObject{}
+		}
+	}
+	if schema, ok := wellKnownSchemas[msg.Desc.FullName()]; ok {
+		return exampleScalarValue(schema)
+	}
+	seen = append(seen, msg.Desc.FullName())
+	obj := jsonObject{}
+	for _, f := range msg.Fields {
+		obj = append(obj, jsonMember{f.Desc.JSONName(), exampleFieldValue(f, seen)})
+	}
+	return obj
+}
+
+// exampleFieldValue returns a placeholder JSON value for f.
+func exampleFieldValue(f *protogen.Field, seen []protoreflect.FullName) interface{} {
+	switch {
+	case f.Desc.IsMap():
+		return jsonObject{}
+	case f.Desc.IsList():
+		return []interface{}{exampleSingularValue(f, seen)}
+	}
+	return exampleSingularValue(f, seen)
+}
+
+func exampleSingularValue(f *protogen.Field, seen []protoreflect.FullName) interface{} {
+	switch {
+	case f.Message != nil:
+		return exampleMessageValue(f.Message, seen)
+	case f.Enum != nil && len(f.Enum.Values) > 0:
+		return string(f.Enum.Values[0].Desc.Name())
+	}
+	return exampleScalarValue(scalarSchemas[f.Desc.Kind()])
+}
+
+// examplePlainValue returns the placeholder value v as it is written in a
+// query string, e.g. "0" for 0. Repeated values are written as their
+// element.
+func examplePlainValue(v interface{}) string {
+	if list, ok := v.([]interface{}); ok && len(list) > 0 {
+		v = list[0]
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// exampleScalarValue returns the zero value of a scalar schema.
+func exampleScalarValue(schema openAPISchema) interface{} {
+	switch schema.Type {
+	case "boolean":
+		return false
+	case "integer", "number":
+		return 0
+	case "string":
+		if schema.Format == "int64" || schema.Format == "uint64" {
+			return "0"
+		}
+		return ""
+	case "object":
+		return jsonObject{}
+	case "array":
+		return []interface{}{}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// ScalarType describes a protobuf scalar value type and the types it maps to
+// in generated code, for the scalar value types appendix of documents.
+type ScalarType struct {
+	// ProtoType is the name of the type in .proto files, e.g. "int32",
+	// which full_field_type returns for fields of the type.
+	ProtoType string
+	// Notes describes the encoding of the type.
+	Notes string
+
+	CppType    string
+	JavaType   string
+	PythonType string
+	GoType     string
+}
+
+// scalarTypes are the scalar value types in the order of the protobuf
+// language guide, returned by the scalar_types template function.
+var scalarTypes = []ScalarType{
+	{"double", "", "double", "double", "float", "float64"},
+	{"float", "", "float", "float", "float", "float32"},
+	{"int32", "Uses variable-length encoding. Inefficient for encoding negative numbers; use sint32 instead if the field is likely to have negative values.", "int32", "int", "int", "int32"},
+	{"int64", "Uses variable-length encoding. Inefficient for encoding negative numbers; use sint64 instead if the field is likely to have negative values.", "int64", "long", "int", "int64"},
+	{"uint32", "Uses variable-length encoding.", "uint32", "int", "int", "uint32"},
+	{"uint64", "Uses variable-length encoding.", "uint64", "long", "int", "uint64"},
+	{"sint32", "Uses variable-length encoding. Signed int value. These more efficiently encode negative numbers than regular int32s.", "int32", "int", "int", "int32"},
+	{"sint64", "Uses variable-length encoding. Signed int value. These more efficiently encode negative numbers than regular int64s.", "int64", "long", "int", "int64"},
+	{"fixed32", "Always four bytes. More efficient than uint32 if values are often greater than 2^28.", "uint32", "int", "int", "uint32"},
+	{"fixed64", "Always eight bytes. More efficient than uint64 if values are often greater than 2^56.", "uint64", "long", "int", "uint64"},
+	{"sfixed32", "Always four bytes.", "int32", "int", "int", "int32"},
+	{"sfixed64", "Always eight bytes.", "int64", "long", "int", "int64"},
+	{"bool", "", "bool", "boolean", "bool", "bool"},
+	{"string", "A string must always contain UTF-8 encoded or 7-bit ASCII text, and cannot be longer than 2^32.", "string", "String", "str", "string"},
+	{"bytes", "May contain any arbitrary sequence of bytes no longer than 2^32.", "string", "ByteString", "bytes", "[]byte"},
+}
+
+// Names of scalar types, see GenOpts.ScalarNames.
+const (
+	// scalarNamesProto names scalar types as in .proto files, e.g. "int32".
+	scalarNamesProto = "proto"
+	// scalarNamesGeneric names them by genericScalarNames, for readers who
+	// don't know protobuf, e.g. "32-bit integer".
+	scalarNamesGeneric = "generic"
+)
+
+// genericScalarNames are the language-agnostic names of the scalar types.
+var genericScalarNames = map[string]string{
+	"double":   "64-bit float",
+	"float":    "32-bit float",
+	"int32":    "32-bit integer",
+	"int64":    "64-bit integer",
+	"uint32":   "32-bit unsigned integer",
+	"uint64":   "64-bit unsigned integer",
+	"sint32":   "32-bit integer",
+	"sint64":   "64-bit integer",
+	"fixed32":  "32-bit unsigned integer",
+	"fixed64":  "64-bit unsigned integer",
+	"sfixed32": "32-bit integer",
+	"sfixed64": "64-bit integer",
+	"bool":     "boolean",
+	"string":   "string",
+	"bytes":    "byte string",
+}
+
+// scalarFlag collects the type=name pairs of repeated scalar parameters.
+// Like wktFlag, each pair is passed as a parameter of its own.
+type scalarFlag []string
+
+func (f *scalarFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *scalarFlag) Set(s string) error {
+	pair := strings.SplitN(s, "=", 2)
+	if len(pair) != 2 || pair[1] == "" {
+		return fmt.Errorf("scalar %q is not of the form type=name", s)
+	}
+	if _, ok := genericScalarNames[pair[0]]; !ok {
+		return fmt.Errorf("scalar %q: %q is not a scalar type", s, pair[0])
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// scalarType returns the name templates display for the scalar type named
+// name in .proto files: a Scalars pair for it, or else its name of
+// o.ScalarNames. Other names, such as those of messages, are returned as is.
+func (o *GenOpts) scalarType(name string) string {
+	display := name
+	if generic, ok := genericScalarNames[name]; ok && o.ScalarNames == scalarNamesGeneric {
+		display = generic
+	}
+	for _, kv := range o.Scalars {
+		pair := strings.SplitN(kv, "=", 2)
+		if pair[0] == name {
+			display = pair[1]
+		}
+	}
+	return display
+}
+
+// fieldType returns the type of f as templates display it: fieldType with
+// the scalar types named by scalarType, and the declared type of map fields,
+// e.g. "map<string, Label>", rather than the name of their entry message.
+func (o *GenOpts) fieldType(f *protogen.Field) string {
+	if f.Desc.IsMap() {
+		return o.mapType(f)
+	}
+	if f.Message == nil && f.Enum == nil {
+		return o.scalarType(fieldType(f))
+	}
+	return fieldType(f)
+}
+
+// mapType returns mapType with the scalar types named by scalarType, e.g.
+// "map<string, 64-bit integer>".
+func (o *GenOpts) mapType(f *protogen.Field) string {
+	if !f.Desc.IsMap() {
+		return o.fieldType(f)
+	}
+	return fmt.Sprintf("map<%s, %s>", o.fieldType(f.Message.Fields[0]), o.fieldType(f.Message.Fields[1]))
+}
+
+// mapKeyType returns the key type of the map field f, e.g. "string", or ""
+// when f isn't a map.
+func (o *GenOpts) mapKeyType(f *protogen.Field) string {
+	if !f.Desc.IsMap() {
+		return ""
+	}
+	return o.fieldType(f.Message.Fields[0])
+}
+
+// mapValueType returns the value type of the map field f, e.g. "Label", or
+// "" when f isn't a map. type_link and field_anchor link map fields to
+// their value type.
+func (o *GenOpts) mapValueType(f *protogen.Field) string {
+	if !f.Desc.IsMap() {
+		return ""
+	}
+	return o.fieldType(f.Message.Fields[1])
+}
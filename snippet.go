@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// snippetIndent indents the declarations of a proto snippet.
+const snippetIndent = "  "
+
+// protoSnippet returns the declaration of a message, enum or service as it
+// could be written in its .proto file, e.g. for a fenced code block next to
+// the field table. The declaration is reconstructed from the descriptor in
+// a canonical layout: comments are left out, types are named relative to
+// the package of the file and only the deprecated, default, json_name and
+// allow_alias options are kept. Excluded declarations are left out, as
+// everywhere else.
+func protoSnippet(d interface{}) (string, error) {
+	var b strings.Builder
+	switch d := d.(type) {
+	case *protogen.Message:
+		writeMessageSnippet(&b, d, "")
+	case *protogen.Enum:
+		writeEnumSnippet(&b, d, "")
+	case *protogen.Service:
+		writeServiceSnippet(&b, d)
+	default:
+		return "", fmt.Errorf("proto_snippet of %T, want a message, enum or service", d)
+	}
+	return b.String(), nil
+}
+
+func writeMessageSnippet(b *strings.Builder, m *protogen.Message, indent string) {
+	fmt.Fprintf(b, "%smessage %s {\n", indent, m.Desc.Name())
+	writeMessageBody(b, m, indent+snippetIndent)
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// writeMessageBody writes the options, fields, oneofs, reserved statements
+// and nested declarations of m, which groups share with messages.
+func writeMessageBody(b *strings.Builder, m *protogen.Message, indent string) {
+	if isDeprecated(m.Desc) {
+		fmt.Fprintf(b, "%soption deprecated = true;\n", indent)
+	}
+	written := make(map[*protogen.Oneof]bool)
+	for _, f := range m.Fields {
+		if !inRealOneof(f) {
+			writeFieldSnippet(b, f, indent)
+			continue
+		}
+		// A oneof is declared where its first field is.
+		if o := f.Oneof; !written[o] {
+			written[o] = true
+			fmt.Fprintf(b, "%soneof %s {\n", indent, o.Desc.Name())
+			for _, f := range o.Fields {
+				writeFieldSnippet(b, f, indent+snippetIndent)
+			}
+			fmt.Fprintf(b, "%s}\n", indent)
+		}
+	}
+	if ranges := reservedRanges(m); len(ranges) > 0 {
+		fmt.Fprintf(b, "%sreserved %s;\n", indent, strings.Join(ranges, ", "))
+	}
+	if names := reservedNames(m); len(names) > 0 {
+		fmt.Fprintf(b, "%sreserved %s;\n", indent, quoteAll(names))
+	}
+	for _, nested := range nestedMessages(m) {
+		if !isGroup(nested) {
+			writeMessageSnippet(b, nested, indent)
+		}
+	}
+	for _, e := range m.Enums {
+		writeEnumSnippet(b, e, indent)
+	}
+}
+
+func writeFieldSnippet(b *strings.Builder, f *protogen.Field, indent string) {
+	b.WriteString(indent)
+	if label := fieldLabel(f); label != "" && !f.Desc.IsMap() {
+		b.WriteString(label + " ")
+	}
+	var options []string
+	if isDeprecated(f.Desc) {
+		options = append(options, "deprecated = true")
+	}
+	if v := defaultValue(f); v != "" {
+		options = append(options, "default = "+v)
+	}
+	if f.Desc.HasJSONName() && f.Desc.JSONName() != defaultJSONName(f.Desc.Name()) {
+		options = append(options, "json_name = "+strconv.Quote(f.Desc.JSONName()))
+	}
+	var opts string
+	if len(options) > 0 {
+		opts = " [" + strings.Join(options, ", ") + "]"
+	}
+	if isGroup(f) {
+		fmt.Fprintf(b, "group %s = %d%s {\n", f.Message.Desc.Name(), f.Desc.Number(), opts)
+		writeMessageBody(b, f.Message, indent+snippetIndent)
+		fmt.Fprintf(b, "%s}\n", indent)
+		return
+	}
+	fmt.Fprintf(b, "%s %s = %d%s;\n", snippetType(f), f.Desc.Name(), f.Desc.Number(), opts)
+}
+
+// snippetType returns the type of f as declared in its file.
+func snippetType(f *protogen.Field) string {
+	if f.Desc.IsMap() {
+		return fmt.Sprintf("map<%s, %s>", snippetType(f.Message.Fields[0]), snippetType(f.Message.Fields[1]))
+	}
+	switch {
+	case f.Message != nil:
+		return relativeName(f.Message.Desc, f.Desc.ParentFile().Package())
+	case f.Enum != nil:
+		return relativeName(f.Enum.Desc, f.Desc.ParentFile().Package())
+	}
+	return f.Desc.Kind().String()
+}
+
+// relativeName returns the name of d as written in a file of package pkg:
+// its long name within pkg and its full name outside of it.
+func relativeName(d protoreflect.Descriptor, pkg protoreflect.FullName) string {
+	if d.ParentFile().Package() == pkg {
+		return longName(d)
+	}
+	return string(d.FullName())
+}
+
+// defaultJSONName returns the JSON name protoc derives from a field name,
+// which needs no json_name option.
+func defaultJSONName(name protoreflect.Name) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper && 'a' <= r && r <= 'z':
+			b.WriteRune(r - 'a' + 'A')
+			upper = false
+		default:
+			b.WriteRune(r)
+			upper = false
+		}
+	}
+	return b.String()
+}
+
+func writeEnumSnippet(b *strings.Builder, e *protogen.Enum, indent string) {
+	fmt.Fprintf(b, "%senum %s {\n", indent, e.Desc.Name())
+	inner := indent + snippetIndent
+	if opts, ok := e.Desc.Options().(*descriptorpb.EnumOptions); ok && opts.GetAllowAlias() {
+		fmt.Fprintf(b, "%soption allow_alias = true;\n", inner)
+	}
+	if isDeprecated(e.Desc) {
+		fmt.Fprintf(b, "%soption deprecated = true;\n", inner)
+	}
+	for _, v := range enumValues(e) {
+		var opts string
+		if isDeprecated(v.Desc) {
+			opts = " [deprecated = true]"
+		}
+		fmt.Fprintf(b, "%s%s = %d%s;\n", inner, v.Desc.Name(), v.Desc.Number(), opts)
+	}
+	var ranges []string
+	rs := e.Desc.ReservedRanges()
+	for i := 0; i < rs.Len(); i++ {
+		// Unlike those of messages, the ranges of enums are inclusive.
+		r := rs.Get(i)
+		if r[0] == r[1] {
+			ranges = append(ranges, strconv.Itoa(int(r[0])))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d to %d", r[0], r[1]))
+		}
+	}
+	if len(ranges) > 0 {
+		fmt.Fprintf(b, "%sreserved %s;\n", inner, strings.Join(ranges, ", "))
+	}
+	var names []string
+	ns := e.Desc.ReservedNames()
+	for i := 0; i < ns.Len(); i++ {
+		names = append(names, string(ns.Get(i)))
+	}
+	if len(names) > 0 {
+		fmt.Fprintf(b, "%sreserved %s;\n", inner, quoteAll(names))
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+func writeServiceSnippet(b *strings.Builder, s *protogen.Service) {
+	fmt.Fprintf(b, "service %s {\n", s.Desc.Name())
+	if isDeprecated(s.Desc) {
+		fmt.Fprintf(b, "%soption deprecated = true;\n", snippetIndent)
+	}
+	pkg := s.Desc.ParentFile().Package()
+	for _, m := range s.Methods {
+		var input, output string
+		if m.Desc.IsStreamingClient() {
+			input = "stream "
+		}
+		if m.Desc.IsStreamingServer() {
+			output = "stream "
+		}
+		input += relativeName(m.Input.Desc, pkg)
+		output += relativeName(m.Output.Desc, pkg)
+		fmt.Fprintf(b, "%srpc %s(%s) returns (%s)", snippetIndent, m.Desc.Name(), input, output)
+		if isDeprecated(m.Desc) {
+			fmt.Fprintf(b, " {\n%[1]s%[1]soption deprecated = true;\n%[1]s}\n", snippetIndent)
+		} else {
+			b.WriteString(";\n")
+		}
+	}
+	b.WriteString("}\n")
+}
+
+// quoteAll returns names quoted and separated by commas, as reserved
+// statements list them.
+func quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = strconv.Quote(n)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sort"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+// Orders of declarations, see GenOpts.Sort.
+const (
+	// sortDeclaration keeps the order of the .proto files.
+	sortDeclaration = "declaration"
+	// sortName orders messages, enums, fields, oneofs and enum values by
+	// name.
+	sortName = "name"
+	// sortNumber orders fields and enum values by number and keeps messages
+	// and enums, which have none, in declaration order.
+	sortNumber = "number"
+)
+
+// sortDeclarations orders the messages and enums of file, along with their
+// fields and values, by o.Sort, so that every format renders them in that
+// order. The fields of a oneof are ordered among themselves and stay in
+// their oneof.
+func (o *GenOpts) sortDeclarations(file *protogen.File) {
+	if o.Sort == "" || o.Sort == sortDeclaration {
+		return
+	}
+	o.sortMessages(file.Messages)
+	o.sortEnums(file.Enums)
+}
+
+func (o *GenOpts) sortMessages(msgs []*protogen.Message) {
+	if o.Sort == sortName {
+		sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Desc.Name() < msgs[j].Desc.Name() })
+	}
+	for _, m := range msgs {
+		o.sortFields(m.Fields)
+		for _, oneof := range m.Oneofs {
+			o.sortFields(oneof.Fields)
+		}
+		if o.Sort == sortName {
+			sort.SliceStable(m.Oneofs, func(i, j int) bool { return m.Oneofs[i].Desc.Name() < m.Oneofs[j].Desc.Name() })
+		}
+		o.sortMessages(m.Messages)
+		o.sortEnums(m.Enums)
+	}
+}
+
+func (o *GenOpts) sortFields(fields []*protogen.Field) {
+	sort.SliceStable(fields, func(i, j int) bool {
+		if o.Sort == sortName {
+			return fields[i].Desc.Name() < fields[j].Desc.Name()
+		}
+		return fields[i].Desc.Number() < fields[j].Desc.Number()
+	})
+}
+
+func (o *GenOpts) sortEnums(enums []*protogen.Enum) {
+	if o.Sort == sortName {
+		sort.SliceStable(enums, func(i, j int) bool { return enums[i].Desc.Name() < enums[j].Desc.Name() })
+	}
+	for _, e := range enums {
+		values := e.Values
+		sort.SliceStable(values, func(i, j int) bool {
+			if o.Sort == sortName {
+				return values[i].Desc.Name() < values[j].Desc.Name()
+			}
+			return values[i].Desc.Number() < values[j].Desc.Number()
+		})
+	}
+}
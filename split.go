@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Ways of splitting the documentation into files, see GenOpts.Split.
+const (
+	// splitFile generates a document per .proto file.
+	splitFile = "file"
+	// splitService generates a document per service, with the messages and
+	// enums only its methods reference, and a types document per .proto file
+	// with the remaining declarations, including those shared by services.
+	splitService = "service"
+	// splitPage generates a page per service and top-level message and
+	// enum, and an index page listing them. Formats opt in by defining
+	// "page" and "index" templates.
+	splitPage = "page"
+)
+
+// indexPageName is the base name of the index page when splitting by page.
+const indexPageName = "index"
+
+// typesDocSuffix is appended to the generated filename prefix of a .proto
+// file to name its types document when splitting by service.
+const typesDocSuffix = ".types"
+
+// splitDocs records which documents declarations are rendered into when
+// splitting by service, so that links point at the right document.
+type splitDocs struct {
+	// byType holds the document of each top-level service, message and
+	// enum.
+	byType map[protoreflect.FullName]string
+	// current is the document being rendered.
+	current string
+	// index is the index page when splitting by page.
+	index string
+}
+
+// relPath returns the path of the document declaring d relative to the
+// current document, or "" when d is declared in the current document.
+func (s *splitDocs) relPath(d protoreflect.Descriptor) (string, bool) {
+	doc, ok := s.byType[topLevelName(d)]
+	if !ok {
+		return "", false
+	}
+	if doc == s.current {
+		return "", true
+	}
+	return s.rel(doc), true
+}
+
+// rel returns the path of doc relative to the current document.
+func (s *splitDocs) rel(doc string) string {
+	rel, err := filepath.Rel(filepath.Dir(s.current), doc)
+	if err != nil {
+		return doc
+	}
+	return filepath.ToSlash(rel)
+}
+
+// pageLink returns the href of the section documenting d. When splitting by
+// page it points at the page d is on, otherwise at the current document.
+func (o *GenOpts) pageLink(d protoreflect.Descriptor) string {
+	var rel string
+	if o.split != nil {
+		rel, _ = o.split.relPath(d)
+	}
+	return rel + "#" + o.anchor(d.FullName())
+}
+
+// indexLink returns the href of the index page when splitting by page.
+func (o *GenOpts) indexLink() string {
+	if o.split == nil || o.split.index == "" {
+		return "#top"
+	}
+	return o.split.rel(o.split.index)
+}
+
+// topLevelName returns the full name of the top-level declaration enclosing
+// d, or of d itself.
+func topLevelName(d protoreflect.Descriptor) protoreflect.FullName {
+	for {
+		p := d.Parent()
+		if _, ok := p.(protoreflect.FileDescriptor); ok || p == nil {
+			return d.FullName()
+		}
+		d = p
+	}
+}
+
+// splitDoc is a document generated when splitting by service.
+type splitDoc struct {
+	filename string
+	// file holds the declarations of the document: a single service and
+	// the messages and enums it references, or the remaining declarations
+	// of a .proto file.
+	file *protogen.File
+}
+
+// generateSplit generates a document per service of files, with the
+// messages and enums of its file that no other service of the file
+// references, and a types document per file for the remaining ones: those
+// that no service or several services reference. Services are named after
+// themselves in the directory of their .proto file, with a numeric suffix if
+// another service already has the name, e.g. "acme/v1/UserService-2.md".
+func (o *GenOpts) generateSplit(gen *protogen.Plugin, files []*protogen.File) error {
+	var docs []splitDoc
+	taken := make(map[string]bool)
+	name := func(base string) string {
+		filename := base + "." + o.fileSuffix()
+		for i := 2; taken[filename]; i++ {
+			filename = fmt.Sprintf("%s-%d.%s", base, i, o.fileSuffix())
+		}
+		taken[filename] = true
+		return o.docPath(filename)
+	}
+	for _, f := range files {
+		dir := path.Dir(f.GeneratedFilenamePrefix)
+		closures := make([]map[protoreflect.FullName]bool, len(f.Services))
+		services := make(map[protoreflect.FullName]int)
+		for i, s := range f.Services {
+			closures[i] = serviceTypes(f, s)
+			for n := range closures[i] {
+				services[n]++
+			}
+		}
+		// Only the declarations referenced by a single service are
+		// documented with it, so that each is rendered once.
+		own := make(map[protoreflect.FullName]bool)
+		for n, count := range services {
+			own[n] = count == 1
+		}
+		for i, s := range f.Services {
+			mine := make(map[protoreflect.FullName]bool)
+			for n := range closures[i] {
+				mine[n] = own[n]
+			}
+			doc := *f
+			doc.Services = []*protogen.Service{s}
+			doc.Messages = filterMessages(f.Messages, mine, true)
+			doc.Enums = filterEnums(f.Enums, mine, true)
+			doc.Extensions = nil
+			docs = append(docs, splitDoc{filename: name(path.Join(dir, string(s.Desc.Name()))), file: &doc})
+		}
+		types := *f
+		types.Services = nil
+		types.Messages = filterMessages(f.Messages, own, false)
+		types.Enums = filterEnums(f.Enums, own, false)
+		if len(types.Messages) > 0 || len(types.Enums) > 0 || len(types.Extensions) > 0 {
+			docs = append(docs, splitDoc{filename: name(f.GeneratedFilenamePrefix + typesDocSuffix), file: &types})
+		}
+	}
+
+	o.split = &splitDocs{byType: make(map[protoreflect.FullName]string)}
+	defer func() { o.split = nil }()
+	return o.renderSplitDocs(gen, docs)
+}
+
+// renderSplitDocs records the documents of the declarations of docs in
+// o.split and renders them.
+func (o *GenOpts) renderSplitDocs(gen *protogen.Plugin, docs []splitDoc) error {
+	seen := make(map[string]bool)
+	for _, doc := range docs {
+		// Flattening may give documents of different directories a name.
+		if seen[doc.filename] {
+			return fmt.Errorf("several documents are generated to %v", doc.filename)
+		}
+		seen[doc.filename] = true
+		for _, d := range doc.declarations() {
+			o.split.byType[d.FullName()] = doc.filename
+		}
+	}
+	for i, doc := range docs {
+		o.split.current = doc.filename
+		g := gen.NewGeneratedFile(doc.filename, doc.file.GoImportPath)
+		data := &TemplateData{File: doc.file, Files: []*protogen.File{doc.file}, Index: i}
+		data.Packages = groupByPackage(data.Files)
+		if err := o.render(g, doc.filename, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// declarations returns the descriptors of the top-level services, messages
+// and enums of the document.
+func (doc splitDoc) declarations() []protoreflect.Descriptor {
+	var decls []protoreflect.Descriptor
+	for _, s := range doc.file.Services {
+		decls = append(decls, s.Desc)
+	}
+	for _, m := range doc.file.Messages {
+		decls = append(decls, m.Desc)
+	}
+	for _, e := range doc.file.Enums {
+		decls = append(decls, e.Desc)
+	}
+	return decls
+}
+
+// generatePages generates a page per service and top-level message and enum
+// of files, named after its full name in the directory of its .proto file,
+// e.g. "acme/v1/acme.v1.User.html". Nested messages and enums are documented
+// on the page of their top-level message. An index page lists every page
+// along with the file-level extensions.
+func (o *GenOpts) generatePages(gen *protogen.Plugin, files []*protogen.File) error {
+	if _, ok := o.formatRenderer(); ok {
+		return fmt.Errorf("format %q does not support split=%s", o.Format, splitPage)
+	}
+	var docs []splitDoc
+	for _, f := range files {
+		dir := path.Dir(f.GeneratedFilenamePrefix)
+		name := func(d protoreflect.Descriptor) string {
+			return o.docPath(path.Join(dir, string(d.FullName())+"."+o.fileSuffix()))
+		}
+		for _, s := range f.Services {
+			page := *f
+			page.Services, page.Messages, page.Enums, page.Extensions = []*protogen.Service{s}, nil, nil, nil
+			docs = append(docs, splitDoc{filename: name(s.Desc), file: &page})
+		}
+		for _, m := range f.Messages {
+			page := *f
+			page.Services, page.Messages, page.Enums, page.Extensions = nil, []*protogen.Message{m}, nil, nil
+			docs = append(docs, splitDoc{filename: name(m.Desc), file: &page})
+		}
+		for _, e := range f.Enums {
+			page := *f
+			page.Services, page.Messages, page.Enums, page.Extensions = nil, nil, []*protogen.Enum{e}, nil
+			docs = append(docs, splitDoc{filename: name(e.Desc), file: &page})
+		}
+	}
+
+	index := o.outPath(indexPageName + "." + o.fileSuffix())
+	o.split = &splitDocs{byType: make(map[protoreflect.FullName]string), index: index}
+	defer func() { o.split = nil }()
+	if err := o.renderSplitDocs(gen, docs); err != nil {
+		return err
+	}
+	o.split.current = index
+	return o.render(gen.NewGeneratedFile(index, ""), index, &TemplateData{Files: files, Packages: groupByPackage(files)})
+}
+
+// serviceTypes returns the full names of the top-level messages and enums of
+// f that the methods of s reference, directly or through the fields of
+// other messages.
+func serviceTypes(f *protogen.File, s *protogen.Service) map[protoreflect.FullName]bool {
+	topLevel := make(map[protoreflect.FullName]*protogen.Message)
+	for _, m := range f.Messages {
+		topLevel[m.Desc.FullName()] = m
+	}
+	w := newTypeWalker(true)
+	for _, m := range s.Methods {
+		w.walk(m.Input)
+		w.walk(m.Output)
+	}
+	// The whole top-level message is rendered, so the messages nested next
+	// to a referenced message are referenced as well. Walking appends to
+	// w.messages, which the loop picks up.
+	for i := 0; i < len(w.messages); i++ {
+		if parent, ok := topLevel[topLevelName(w.messages[i].Desc)]; ok {
+			w.walk(parent)
+		}
+	}
+	names := make(map[protoreflect.FullName]bool)
+	for _, m := range w.messages {
+		names[topLevelName(m.Desc)] = true
+	}
+	for _, e := range w.enums {
+		names[topLevelName(e.Desc)] = true
+	}
+	return names
+}
+
+// filterMessages returns the messages of msgs whose names are in names, or
+// aren't when in is false.
+func filterMessages(msgs []*protogen.Message, names map[protoreflect.FullName]bool, in bool) []*protogen.Message {
+	var kept []*protogen.Message
+	for _, m := range msgs {
+		if names[m.Desc.FullName()] == in {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// filterEnums returns the enums of enums whose names are in names, or
+// aren't when in is false.
+func filterEnums(enums []*protogen.Enum, names map[protoreflect.FullName]bool, in bool) []*protogen.Enum {
+	var kept []*protogen.Enum
+	for _, e := range enums {
+		if names[e.Desc.FullName()] == in {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// templateDirsFlag collects the directories of repeated templates
+// parameters. protoc splits parameters on commas, so each directory is
+// usually passed as a parameter of its own, but a comma-separated list is
+// accepted as well.
+type templateDirsFlag []string
+
+func (f *templateDirsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *templateDirsFlag) Set(s string) error {
+	for _, dir := range strings.Split(s, ",") {
+		if dir != "" {
+			*f = append(*f, dir)
+		}
+	}
+	return nil
+}
+
+// templateNotFound returns the error for a template name that none of
+// o.TemplateDirs nor the embedded templates have, listing the files found in
+// each directory since the name or the directory is likely misspelled.
+func (o *GenOpts) templateNotFound(name string) error {
+	var found []string
+	for _, dir := range o.TemplateDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, e := range entries {
+			if e.IsDir() {
+				names = append(names, e.Name()+"/")
+			} else {
+				names = append(names, e.Name())
+			}
+		}
+		if len(names) == 0 {
+			names = append(names, "no files")
+		}
+		found = append(found, fmt.Sprintf("%s has %s", dir, strings.Join(names, ", ")))
+	}
+	return fmt.Errorf("format %q has no template %s: %s", o.Format, name, strings.Join(found, "; "))
+}
+
+// templateLayer is a source of templates: one of the templates directories,
+// or the embedded templates when dir is empty.
+type templateLayer struct {
+	fsys fs.FS
+	dir  string
+}
+
+// templateFile is a template file and the layer it is read from.
+type templateFile struct {
+	layer *templateLayer
+	name  string
+}
+
+// source returns the path of f for errors.
+func (f templateFile) source() string {
+	if f.layer.dir == "" {
+		return "embedded templates/" + f.name
+	}
+	return filepath.Join(f.layer.dir, filepath.FromSlash(f.name))
+}
+
+// layeredFS merges file systems. A file is opened from the first layer that
+// has it, and directories list the entries of every layer.
+type layeredFS []fs.FS
+
+func (l layeredFS) Open(name string) (fs.File, error) {
+	for _, fsys := range l {
+		f, err := fsys.Open(name)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (l layeredFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var (
+		entries []fs.DirEntry
+		found   bool
+		seen    = make(map[string]bool)
+	)
+	for _, fsys := range l {
+		layer, err := fs.ReadDir(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, e := range layer {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
@@ -8,3 +8,7 @@ package thirdparty
 
 //go:generate mkdir -p github.com/pseudomuto/protokit/fixtures
 //go:generate curl -fsSL https://github.com/pseudomuto/protokit/raw/master/fixtures/extend.proto -o github.com/pseudomuto/protokit/fixtures/extend.proto
+
+//go:generate mkdir -p google/api
+//go:generate curl -fsSL https://github.com/googleapis/googleapis/raw/master/google/api/http.proto -o google/api/http.proto
+//go:generate curl -fsSL https://github.com/googleapis/googleapis/raw/master/google/api/annotations.proto -o google/api/annotations.proto
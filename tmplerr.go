@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// sourcedTemplate is a parsed template that reports execution errors with
+// the format, the path of the template file at fault, whether custom or
+// embedded, and the declaration last passed to a template function.
+type sourcedTemplate struct {
+	templateExecutor
+	o *GenOpts
+	// sources maps the names the files were parsed under to their paths.
+	sources map[string]string
+}
+
+// templateSources returns the paths of files by the names they are parsed
+// under.
+func templateSources(files []templateFile) map[string]string {
+	sources := make(map[string]string)
+	for _, f := range files {
+		sources[f.name] = f.source()
+	}
+	return sources
+}
+
+// sourceList returns the paths of sources in the order of files, for parse
+// errors to list the files parsed together.
+func sourceList(files []templateFile, extra ...string) string {
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.source())
+	}
+	return strings.Join(append(paths, extra...), ", ")
+}
+
+func (t sourcedTemplate) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	t.o.rendering = nil
+	err := t.templateExecutor.ExecuteTemplate(w, name, data)
+	if err == nil {
+		return nil
+	}
+	return &templateError{msg: t.o.templateErrorMessage(err, t.sources), err: err}
+}
+
+// templateError is an execution error of a sourcedTemplate.
+type templateError struct {
+	msg string
+	err error
+}
+
+func (e *templateError) Error() string { return e.msg }
+func (e *templateError) Unwrap() error { return e.err }
+
+// templateLocation matches the location text/template and html/template
+// start their errors with, e.g. "template: markdown.tmpl:42:3: ".
+var templateLocation = regexp.MustCompile(`^(?:html/)?template: ?([^:]+):(\d+)`)
+
+// templateErrorMessage rewrites the error of executing a template to name
+// the format and the path of the file, e.g.
+//
+//	format "markdown": templates/markdown.tmpl:42:3: executing "field" at
+//	<field_type .>: ... (rendering field com.acme.v1.User.name)
+func (o *GenOpts) templateErrorMessage(err error, sources map[string]string) string {
+	msg := err.Error()
+	if m := templateLocation.FindStringSubmatchIndex(msg); m != nil {
+		name := msg[m[2]:m[3]]
+		if source, ok := sources[name]; ok {
+			name = source
+		}
+		msg = name + msg[m[3]:]
+	}
+	msg = fmt.Sprintf("format %q: %s", o.Format, msg)
+	if o.rendering != nil {
+		msg += fmt.Sprintf(" (rendering %s %s)", declarationKind(o.rendering), o.rendering.FullName())
+	}
+	return msg
+}
+
+// declarationKind names the kind of d, e.g. "message".
+func declarationKind(d protoreflect.Descriptor) string {
+	switch d := d.(type) {
+	case protoreflect.FileDescriptor:
+		return "file"
+	case protoreflect.MessageDescriptor:
+		return "message"
+	case protoreflect.FieldDescriptor:
+		if d.IsExtension() {
+			return "extension"
+		}
+		return "field"
+	case protoreflect.OneofDescriptor:
+		return "oneof"
+	case protoreflect.EnumDescriptor:
+		return "enum"
+	case protoreflect.EnumValueDescriptor:
+		return "enum value"
+	case protoreflect.ServiceDescriptor:
+		return "service"
+	case protoreflect.MethodDescriptor:
+		return "method"
+	}
+	return "declaration"
+}
+
+// tracked wraps the template function fn to record the declaration of its
+// arguments in o.rendering, which errors report as the declaration being
+// rendered. Nearly every template passes the declaration it renders to some
+// function, if only to is_deprecated. Functions taking no declarations, such
+// as the text filters, are returned as is.
+func (o *GenOpts) tracked(fn interface{}) interface{} {
+	v := reflect.ValueOf(fn)
+	if !takesDeclaration(v.Type()) {
+		return fn
+	}
+	call := v.Call
+	if v.Type().IsVariadic() {
+		call = v.CallSlice
+	}
+	return reflect.MakeFunc(v.Type(), func(args []reflect.Value) []reflect.Value {
+		for _, arg := range args {
+			if d := argDeclaration(arg); d != nil {
+				o.rendering = d
+			}
+		}
+		return call(args)
+	}).Interface()
+}
+
+var descriptorType = reflect.TypeOf((*protoreflect.Descriptor)(nil)).Elem()
+
+// takesDeclaration reports whether the function type t has a parameter that
+// can hold a declaration.
+func takesDeclaration(t reflect.Type) bool {
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if in.Kind() == reflect.Interface && (in.NumMethod() == 0 || in.Implements(descriptorType)) {
+			return true
+		}
+		if in.Kind() == reflect.Ptr && in.Elem().PkgPath() == "google.golang.org/protobuf/compiler/protogen" {
+			return true
+		}
+	}
+	return false
+}
+
+// argDeclaration returns the declaration of a template function argument,
+// or nil if it isn't one.
+func argDeclaration(arg reflect.Value) protoreflect.Descriptor {
+	if !arg.IsValid() || !arg.CanInterface() {
+		return nil
+	}
+	switch d := arg.Interface().(type) {
+	case *protogen.Message:
+		if d != nil {
+			return d.Desc
+		}
+	case *protogen.Field:
+		if d != nil {
+			return d.Desc
+		}
+	case *protogen.Oneof:
+		if d != nil {
+			return d.Desc
+		}
+	case *protogen.Enum:
+		if d != nil {
+			return d.Desc
+		}
+	case *protogen.EnumValue:
+		if d != nil {
+			return d.Desc
+		}
+	case *protogen.Service:
+		if d != nil {
+			return d.Desc
+		}
+	case *protogen.Method:
+		if d != nil {
+			return d.Desc
+		}
+	case protoreflect.Descriptor:
+		if _, ok := d.(protoreflect.FileDescriptor); !ok {
+			return d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// validateRulesExtension is the protoc-gen-validate option holding the
+// constraints of a field.
+const validateRulesExtension = "validate.rules"
+
+// validationRules returns a summary of the protoc-gen-validate constraints
+// of f, e.g. "min length 1, max length 64", or "" when it has none. Rules
+// that are not recognized are left out.
+func validationRules(f *protogen.Field) string {
+	v, ok := extensionValue(f.Desc, validateRulesExtension)
+	if !ok {
+		return ""
+	}
+	return strings.Join(fieldRules(f.Desc, v.Message()), ", ")
+}
+
+// hasValidationRules reports whether any field of m has constraints, in
+// which case field tables have a column for them.
+func hasValidationRules(m *protogen.Message) bool {
+	for _, f := range m.Fields {
+		if validationRules(f) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldRules summarizes a validate.FieldRules message: the rules of the
+// type of fd, such as validate.StringRules, and the validate.MessageRules.
+func fieldRules(fd protoreflect.FieldDescriptor, rules protoreflect.Message) []string {
+	var summary []string
+	for _, typ := range messageFields(rules) {
+		if typ.fd.Message() == nil {
+			continue
+		}
+		rules := messageFields(typ.v.Message())
+		// Lower bounds read better before upper ones, e.g. ">= 1, < 10".
+		sort.SliceStable(rules, func(i, j int) bool {
+			return isLowerBound(rules[i].fd) && !isLowerBound(rules[j].fd)
+		})
+		for _, r := range rules {
+			if s := ruleSummary(fd, r.fd, r.v); s != "" {
+				summary = append(summary, s)
+			}
+		}
+	}
+	return summary
+}
+
+func isLowerBound(r protoreflect.FieldDescriptor) bool {
+	return r.Name() == "gt" || r.Name() == "gte"
+}
+
+// wellKnownFormats names the string and bytes formats of protoc-gen-validate.
+var wellKnownFormats = map[protoreflect.Name]string{
+	"email":    "email",
+	"hostname": "hostname",
+	"ip":       "IP address",
+	"ipv4":     "IPv4 address",
+	"ipv6":     "IPv6 address",
+	"uri":      "URI",
+	"uri_ref":  "URI reference",
+	"address":  "hostname or IP address",
+	"uuid":     "UUID",
+}
+
+// ruleSummary summarizes the rule r with the value v constraining fd, or
+// returns "" for rules that are not recognized.
+func ruleSummary(fd, r protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	value := func() string { return ruleValue(fd, r, v) }
+	name := r.Name()
+	if r.Kind() == protoreflect.BoolKind && name != "const" {
+		if !v.Bool() {
+			return ""
+		}
+		if format, ok := wellKnownFormats[name]; ok {
+			return format
+		}
+	}
+	switch name {
+	case "required":
+		return "required"
+	case "skip":
+		return "not validated"
+	case "defined_only":
+		return "defined values only"
+	case "unique":
+		return "unique items"
+	case "no_sparse":
+		return "no unset values"
+	case "ignore_empty":
+		return "unless empty"
+	case "lt_now":
+		return "before now"
+	case "gt_now":
+		return "after now"
+	case "const":
+		return "= " + value()
+	case "lt":
+		return "< " + value()
+	case "lte":
+		return "<= " + value()
+	case "gt":
+		return "> " + value()
+	case "gte":
+		return ">= " + value()
+	case "in":
+		return "one of " + value()
+	case "not_in":
+		return "none of " + value()
+	case "len":
+		return "length " + value()
+	case "min_len":
+		return "min length " + value()
+	case "max_len":
+		return "max length " + value()
+	case "len_bytes":
+		return value() + " bytes"
+	case "min_bytes":
+		return "min " + value() + " bytes"
+	case "max_bytes":
+		return "max " + value() + " bytes"
+	case "pattern":
+		return "pattern " + value()
+	case "prefix":
+		return "prefix " + value()
+	case "suffix":
+		return "suffix " + value()
+	case "contains":
+		return "contains " + value()
+	case "not_contains":
+		return "does not contain " + value()
+	case "min_items":
+		return "min " + value() + " items"
+	case "max_items":
+		return "max " + value() + " items"
+	case "min_pairs":
+		return "min " + value() + " pairs"
+	case "max_pairs":
+		return "max " + value() + " pairs"
+	case "within":
+		return "within " + value() + " of now"
+	case "items", "keys", "values":
+		target := fd
+		switch name {
+		case "keys":
+			target = fd.MapKey()
+		case "values":
+			target = fd.MapValue()
+		}
+		if rules := fieldRules(target, v.Message()); len(rules) > 0 {
+			return string(name) + " (" + strings.Join(rules, ", ") + ")"
+		}
+	}
+	return ""
+}
+
+// ruleValue formats the value v of the rule r: enum numbers by the names of
+// the values of fd, durations and timestamps as such, and other values as
+// in the text format.
+func ruleValue(fd, r protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	if r.IsList() {
+		var elems []string
+		l := v.List()
+		for i := 0; i < l.Len(); i++ {
+			elems = append(elems, ruleScalar(fd, r, l.Get(i)))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+	return ruleScalar(fd, r, v)
+}
+
+func ruleScalar(fd, r protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	if r.Kind() == protoreflect.MessageKind {
+		m := v.Message()
+		fields := m.Descriptor().Fields()
+		switch m.Descriptor().FullName() {
+		case "google.protobuf.Duration":
+			seconds, nanos := m.Get(fields.ByName("seconds")).Int(), m.Get(fields.ByName("nanos")).Int()
+			return (time.Duration(seconds)*time.Second + time.Duration(nanos)).String()
+		case "google.protobuf.Timestamp":
+			seconds, nanos := m.Get(fields.ByName("seconds")).Int(), m.Get(fields.ByName("nanos")).Int()
+			return time.Unix(seconds, nanos).UTC().Format(time.RFC3339Nano)
+		}
+	}
+	if fd.Enum() != nil && r.Kind() == protoreflect.Int32Kind {
+		if ev := fd.Enum().Values().ByNumber(protoreflect.EnumNumber(v.Int())); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Int()))
+	}
+	return formatSingularValue(r, v)
+}
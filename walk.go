@@ -0,0 +1,81 @@
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// typeWalker walks the messages and enums referenced by the fields of
+// messages, transitively. Every declaration is visited once, so the walk
+// ends on self-referential and mutually recursive messages, and the walked
+// declarations are collected in the order they are first reached.
+type typeWalker struct {
+	// nested also walks the messages declared in every walked message, for
+	// callers rendering messages along with their nested declarations.
+	nested bool
+
+	visited  map[protoreflect.FullName]bool
+	messages []*protogen.Message
+	enums    []*protogen.Enum
+}
+
+func newTypeWalker(nested bool) *typeWalker {
+	return &typeWalker{nested: nested, visited: make(map[protoreflect.FullName]bool)}
+}
+
+// walk walks m and the declarations its fields reference. Map entry
+// messages are walked through but not collected, map fields reference their
+// key and value types.
+func (w *typeWalker) walk(m *protogen.Message) {
+	if w.visited[m.Desc.FullName()] {
+		return
+	}
+	w.visited[m.Desc.FullName()] = true
+	if !m.Desc.IsMapEntry() {
+		w.messages = append(w.messages, m)
+	}
+	w.walkFields(m)
+	if w.nested {
+		for _, nested := range m.Messages {
+			w.walk(nested)
+		}
+	}
+}
+
+// walkFields walks the declarations the fields of m reference without
+// walking m itself, which is only collected when reached through a cycle.
+func (w *typeWalker) walkFields(m *protogen.Message) {
+	for _, f := range m.Fields {
+		switch {
+		case f.Message != nil:
+			w.walk(f.Message)
+		case f.Enum != nil:
+			w.walkEnum(f.Enum)
+		}
+	}
+}
+
+func (w *typeWalker) walkEnum(e *protogen.Enum) {
+	if w.visited[e.Desc.FullName()] {
+		return
+	}
+	w.visited[e.Desc.FullName()] = true
+	w.enums = append(w.enums, e)
+}
+
+// ReferencedTypes are the messages and enums referenced by a message, see
+// referencedTypes.
+type ReferencedTypes struct {
+	Messages []*protogen.Message
+	Enums    []*protogen.Enum
+}
+
+// referencedTypes returns the messages and enums the fields of m reference,
+// directly or through the fields of other messages, in the order they are
+// first reached. m itself is among them only when it references itself,
+// directly or through a cycle.
+func referencedTypes(m *protogen.Message) ReferencedTypes {
+	w := newTypeWalker(false)
+	w.walkFields(m)
+	return ReferencedTypes{Messages: w.messages, Enums: w.enums}
+}
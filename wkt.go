@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// wktDocsURL is the upstream reference of the google.protobuf well-known
+// types.
+const wktDocsURL = "https://protobuf.dev/reference/protobuf/google.protobuf/"
+
+// wellKnownTypes maps the well-known types that templates render as a
+// friendly description instead of a message reference to that description.
+// GenOpts.WKT overrides it.
+var wellKnownTypes = map[protoreflect.FullName]string{
+	"google.protobuf.Any":         "any message",
+	"google.protobuf.BoolValue":   "nullable bool",
+	"google.protobuf.BytesValue":  "nullable bytes",
+	"google.protobuf.DoubleValue": "nullable double",
+	"google.protobuf.Duration":    "duration",
+	"google.protobuf.Empty":       "empty message",
+	"google.protobuf.FieldMask":   "field mask",
+	"google.protobuf.FloatValue":  "nullable float",
+	"google.protobuf.Int32Value":  "nullable int32",
+	"google.protobuf.Int64Value":  "nullable int64",
+	"google.protobuf.ListValue":   "JSON array",
+	"google.protobuf.NullValue":   "JSON null",
+	"google.protobuf.StringValue": "nullable string",
+	"google.protobuf.Struct":      "JSON object",
+	"google.protobuf.Timestamp":   "RFC 3339 timestamp",
+	"google.protobuf.UInt32Value": "nullable uint32",
+	"google.protobuf.UInt64Value": "nullable uint64",
+	"google.protobuf.Value":       "JSON value",
+}
+
+// wktFlag collects the name=description pairs of repeated wkt parameters.
+// Like frontMatterFlag, each pair is passed as a parameter of its own.
+type wktFlag []string
+
+func (f *wktFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *wktFlag) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("well-known type %q is not of the form name=description", s)
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// wellKnown returns the description of the well-known type named name, or
+// false if it isn't recognized. A WKT pair overrides the description of
+// wellKnownTypes, or adds a type; an empty description stops recognizing
+// the type.
+func (o *GenOpts) wellKnown(name protoreflect.FullName) (string, bool) {
+	display, ok := wellKnownTypes[name]
+	for _, kv := range o.WKT {
+		pair := strings.SplitN(kv, "=", 2)
+		if protoreflect.FullName(pair[0]) == name {
+			display, ok = pair[1], pair[1] != ""
+		}
+	}
+	return display, ok
+}
+
+// wktName returns the full name of the type of f, and whether it is a
+// message or enum at all.
+func wktName(f *protogen.Field) (protoreflect.FullName, bool) {
+	switch {
+	case f.Message != nil:
+		return f.Message.Desc.FullName(), true
+	case f.Enum != nil:
+		return f.Enum.Desc.FullName(), true
+	}
+	return "", false
+}
+
+// isWKT reports whether f holds a recognized well-known type.
+func (o *GenOpts) isWKT(f *protogen.Field) bool {
+	name, ok := wktName(f)
+	if !ok {
+		return false
+	}
+	_, ok = o.wellKnown(name)
+	return ok
+}
+
+// wktDisplay returns the description of the well-known type of f, e.g.
+// "RFC 3339 timestamp", or its type when it isn't one.
+func (o *GenOpts) wktDisplay(f *protogen.Field) string {
+	if name, ok := wktName(f); ok {
+		if display, ok := o.wellKnown(name); ok {
+			return display
+		}
+	}
+	return o.fieldType(f)
+}
+
+// wktLink returns the upstream documentation of the type of f when it is a
+// google.protobuf type, e.g.
+// "https://protobuf.dev/reference/protobuf/google.protobuf/#timestamp", or
+// "" otherwise.
+func wktLink(f *protogen.Field) string {
+	name, ok := wktName(f)
+	if !ok || name.Parent() != wktPackage {
+		return ""
+	}
+	var b strings.Builder
+	prev := ' '
+	for _, r := range string(name.Name()) {
+		if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			b.WriteByte('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+		prev = r
+	}
+	return wktDocsURL + "#" + b.String()
+}